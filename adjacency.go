@@ -0,0 +1,70 @@
+package main
+
+//────────────────────────────
+// Prefecture Adjacency (INCLUDE_ADJACENT)
+//────────────────────────────
+
+// Prefectures sharing a land border. Hokkaido–Aomori and Yamaguchi–Fukuoka are
+// included across their straits, as they are directly connected and close.
+var adjacentPrefectures = map[string][]string{
+	"Hokkaido":  {"Aomori"},
+	"Aomori":    {"Hokkaido", "Iwate", "Akita"},
+	"Iwate":     {"Aomori", "Akita", "Miyagi"},
+	"Miyagi":    {"Iwate", "Akita", "Yamagata", "Fukushima"},
+	"Akita":     {"Aomori", "Iwate", "Miyagi", "Yamagata"},
+	"Yamagata":  {"Akita", "Miyagi", "Fukushima", "Niigata"},
+	"Fukushima": {"Miyagi", "Yamagata", "Niigata", "Gunma", "Tochigi", "Ibaraki"},
+	"Ibaraki":   {"Fukushima", "Tochigi", "Saitama", "Chiba"},
+	"Tochigi":   {"Fukushima", "Ibaraki", "Saitama", "Gunma"},
+	"Gunma":     {"Fukushima", "Tochigi", "Saitama", "Nagano", "Niigata"},
+	"Saitama":   {"Ibaraki", "Tochigi", "Gunma", "Chiba", "Tokyo", "Yamanashi", "Nagano"},
+	"Chiba":     {"Ibaraki", "Saitama", "Tokyo"},
+	"Tokyo":     {"Saitama", "Chiba", "Kanagawa", "Yamanashi"},
+	"Kanagawa":  {"Tokyo", "Yamanashi", "Shizuoka"},
+	"Niigata":   {"Yamagata", "Fukushima", "Gunma", "Nagano", "Toyama"},
+	"Toyama":    {"Niigata", "Nagano", "Gifu", "Ishikawa"},
+	"Ishikawa":  {"Toyama", "Gifu", "Fukui"},
+	"Fukui":     {"Ishikawa", "Gifu", "Shiga", "Kyoto"},
+	"Yamanashi": {"Saitama", "Tokyo", "Kanagawa", "Shizuoka", "Nagano"},
+	"Nagano":    {"Niigata", "Gunma", "Saitama", "Yamanashi", "Shizuoka", "Aichi", "Gifu", "Toyama"},
+	"Gifu":      {"Toyama", "Ishikawa", "Fukui", "Nagano", "Aichi", "Mie", "Shiga"},
+	"Shizuoka":  {"Kanagawa", "Yamanashi", "Nagano", "Aichi"},
+	"Aichi":     {"Shizuoka", "Nagano", "Gifu", "Mie"},
+	"Mie":       {"Aichi", "Gifu", "Shiga", "Kyoto", "Nara", "Wakayama"},
+	"Shiga":     {"Fukui", "Gifu", "Mie", "Kyoto"},
+	"Kyoto":     {"Fukui", "Shiga", "Mie", "Nara", "Osaka", "Hyogo"},
+	"Osaka":     {"Kyoto", "Nara", "Wakayama", "Hyogo"},
+	"Hyogo":     {"Kyoto", "Osaka", "Okayama", "Tottori"},
+	"Nara":      {"Kyoto", "Osaka", "Wakayama", "Mie"},
+	"Wakayama":  {"Osaka", "Nara", "Mie"},
+	"Tottori":   {"Hyogo", "Okayama", "Hiroshima", "Shimane"},
+	"Shimane":   {"Tottori", "Hiroshima", "Yamaguchi"},
+	"Okayama":   {"Hyogo", "Tottori", "Hiroshima"},
+	"Hiroshima": {"Okayama", "Tottori", "Shimane", "Yamaguchi"},
+	"Yamaguchi": {"Hiroshima", "Shimane", "Fukuoka"},
+	"Tokushima": {"Kagawa", "Ehime", "Kochi"},
+	"Kagawa":    {"Tokushima", "Ehime"},
+	"Ehime":     {"Kagawa", "Tokushima", "Kochi"},
+	"Kochi":     {"Tokushima", "Ehime"},
+	"Fukuoka":   {"Yamaguchi", "Saga", "Kumamoto", "Oita"},
+	"Saga":      {"Fukuoka", "Nagasaki"},
+	"Nagasaki":  {"Saga"},
+	"Kumamoto":  {"Fukuoka", "Oita", "Miyazaki", "Kagoshima"},
+	"Oita":      {"Fukuoka", "Kumamoto", "Miyazaki"},
+	"Miyazaki":  {"Oita", "Kumamoto", "Kagoshima"},
+	"Kagoshima": {"Kumamoto", "Miyazaki"},
+	"Okinawa":   {},
+}
+
+// Add the neighbors of each prefecture to the list, without duplicates
+func expandAdjacent(prefectures []string) []string {
+	expanded := append([]string(nil), prefectures...)
+	for _, pref := range prefectures {
+		for _, neighbor := range adjacentPrefectures[pref] {
+			if !containsString(expanded, neighbor) {
+				expanded = append(expanded, neighbor)
+			}
+		}
+	}
+	return expanded
+}
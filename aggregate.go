@@ -0,0 +1,223 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Event Aggregator (AGGREGATE_SETTLE, WAIT_FOR_DETAIL_SECONDS)
+//────────────────────────────
+
+// P2PQuake sends several reports for one quake: the intensity flash
+// (ScalePrompt), the hypocenter (Destination), both, then the detailed
+// intensities (DetailScale), and possibly corrections. All reports share the
+// origin time, which keys the aggregation.
+//
+// Lifecycle of one quake:
+//   - the first report opens it and starts the settle timer;
+//   - every further report is merged in and restarts the timer, but the quake
+//     is never held longer than the maximum wait after its first report;
+//   - it is flushed when the timer fires (or at once on DetailScale when
+//     flushOnFinal is set): the merged report goes through handleEarthquake
+//     once, and the quake is forgotten.
+//
+// Reports arriving after a flush open a new aggregation. They are posted as
+// follow-ups, edited in place in ticker mode, and only ping again when the
+// intensity was upgraded (UPGRADE_REALERT).
+
+// How detailed each report type is; P2PQuake issues them in roughly this order
+var reportDetail = map[string]int{
+	"ScalePrompt":         1,
+	"Destination":         2,
+	"ScaleAndDestination": 3,
+	"DetailScale":         4,
+}
+
+// Whether report a carries more detail than report b
+func moreDetailed(a, b JMAQuake) bool {
+	ra, rb := reportDetail[a.Issue.Type], reportDetail[b.Issue.Type]
+	if ra != rb {
+		return ra > rb
+	}
+	return len(a.Points) > len(b.Points)
+}
+
+// Whether a report names or locates the hypocenter
+func hasHypocenterInfo(h *Hypocenter) bool {
+	return h != nil && (h.Name != "" || hasCoordinates(h))
+}
+
+// Merge a new report into the aggregate. A correction or a report at least as
+// detailed becomes the base; anything the base lacks (hypocenter, max scale,
+// points, tsunami status) is filled in from the other report.
+func mergeReports(cur, next JMAQuake) JMAQuake {
+	base, other := cur, next
+	if isCorrection(next) || !moreDetailed(cur, next) {
+		base, other = next, cur
+	}
+	out := base
+	if !hasHypocenterInfo(out.Earthquake.Hypocenter) && hasHypocenterInfo(other.Earthquake.Hypocenter) {
+		out.Earthquake.Hypocenter = other.Earthquake.Hypocenter
+	}
+	// Destination reports carry no intensity (-1)
+	if out.Earthquake.MaxScale <= 0 && other.Earthquake.MaxScale > 0 {
+		out.Earthquake.MaxScale = other.Earthquake.MaxScale
+	}
+	if len(out.Points) == 0 {
+		out.Points = other.Points
+	}
+	if out.Earthquake.DomesticTsunami == "" || out.Earthquake.DomesticTsunami == "Unknown" {
+		if other.Earthquake.DomesticTsunami != "" {
+			out.Earthquake.DomesticTsunami = other.Earthquake.DomesticTsunami
+		}
+	}
+	if out.Earthquake.ForeignTsunami == "" || out.Earthquake.ForeignTsunami == "Unknown" {
+		if other.Earthquake.ForeignTsunami != "" {
+			out.Earthquake.ForeignTsunami = other.Earthquake.ForeignTsunami
+		}
+	}
+	return out
+}
+
+// A pending timer; *time.Timer satisfies it
+type stopper interface {
+	Stop() bool
+}
+
+type aggregateEvent struct {
+	Merged JMAQuake
+	// IDs of every merged report, oldest first
+	IDs   []string
+	First time.Time
+	timer stopper
+	// Incremented on every reschedule, so that a superseded timer does nothing
+	gen int
+}
+
+type eventAggregator struct {
+	mu           sync.Mutex
+	settle       time.Duration
+	maxWait      time.Duration
+	flushOnFinal bool
+	emit         func(JMAQuake)
+	events       map[string]*aggregateEvent
+	// Replaceable in tests
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) stopper
+}
+
+func newEventAggregator(settle, maxWait time.Duration, flushOnFinal bool, emit func(JMAQuake)) *eventAggregator {
+	if maxWait < settle {
+		maxWait = settle
+	}
+	return &eventAggregator{
+		settle:       settle,
+		maxWait:      maxWait,
+		flushOnFinal: flushOnFinal,
+		emit:         emit,
+		events:       make(map[string]*aggregateEvent),
+		now:          time.Now,
+		afterFunc: func(d time.Duration, f func()) stopper {
+			return time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Merge a report into its quake and (re)start the settle timer
+func (a *eventAggregator) Add(eq JMAQuake) {
+	key := eq.Earthquake.Time
+	now := a.now()
+
+	a.mu.Lock()
+	e, exists := a.events[key]
+	if !exists {
+		e = &aggregateEvent{Merged: eq, First: now}
+		a.events[key] = e
+	} else {
+		e.Merged = mergeReports(e.Merged, eq)
+	}
+	e.IDs = append(e.IDs, eq.ID)
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.gen++
+	delay := a.settle
+	if remaining := e.First.Add(a.maxWait).Sub(now); remaining < delay {
+		delay = remaining
+	}
+	if (a.flushOnFinal && eq.Issue.Type == "DetailScale") || delay <= 0 {
+		delete(a.events, key)
+		a.mu.Unlock()
+		a.flushEvent(e)
+		return
+	}
+	gen := e.gen
+	e.timer = a.afterFunc(delay, func() { a.flush(key, gen) })
+	a.mu.Unlock()
+}
+
+// Timer callback: flush the quake unless a newer report rescheduled it
+func (a *eventAggregator) flush(key string, gen int) {
+	a.mu.Lock()
+	e, ok := a.events[key]
+	if !ok || e.gen != gen {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.events, key)
+	a.mu.Unlock()
+	a.flushEvent(e)
+}
+
+// Flush every pending quake at once (e.g. on shutdown)
+func (a *eventAggregator) FlushAll() {
+	a.mu.Lock()
+	events := a.events
+	a.events = make(map[string]*aggregateEvent)
+	for _, e := range events {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+	}
+	a.mu.Unlock()
+	for _, e := range events {
+		a.flushEvent(e)
+	}
+}
+
+func (a *eventAggregator) flushEvent(e *aggregateEvent) {
+	if len(e.IDs) > 1 && currentEnv().EnableLogger {
+		withFields(logFields{"event_ids": e.IDs}).info("reports_merged", "Merged %d reports into one alert: %s", len(e.IDs), strings.Join(e.IDs, ", "))
+	}
+	a.emit(e.Merged)
+}
+
+// Aggregator in use; nil handles every report at once
+var quakeAggregator *eventAggregator
+
+// Build the aggregator from the configuration. AGGREGATE_SETTLE enables the
+// sliding settle window (capped by AGGREGATE_MAX_WAIT); the older
+// WAIT_FOR_DETAIL_SECONDS is a fixed window that DetailScale ends early.
+func newQuakeAggregator(isDev bool) *eventAggregator {
+	env := currentEnv()
+	emit := func(eq JMAQuake) { handleEarthquake(eq, isDev) }
+	if env.AggregateSettle > 0 {
+		return newEventAggregator(env.AggregateSettle, env.AggregateMaxWait, false, emit)
+	}
+	if env.WaitForDetailSeconds > 0 {
+		wait := time.Duration(env.WaitForDetailSeconds) * time.Second
+		return newEventAggregator(wait, wait, true, emit)
+	}
+	return nil
+}
+
+// Hand an earthquake report to the aggregator, or straight to handleEarthquake
+func aggregateQuake(eq JMAQuake, isDev bool) {
+	if quakeAggregator == nil {
+		handleEarthquake(eq, isDev)
+		return
+	}
+	quakeAggregator.Add(eq)
+}
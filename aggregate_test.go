@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Timer that only fires when the test says so
+type fakeTimer struct {
+	delay   time.Duration
+	fn      func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.stopped = true
+	return true
+}
+
+type aggregatorHarness struct {
+	agg     *eventAggregator
+	now     time.Time
+	timers  []*fakeTimer
+	emitted []JMAQuake
+}
+
+func newHarness(settle, maxWait time.Duration, flushOnFinal bool) *aggregatorHarness {
+	h := &aggregatorHarness{now: time.Date(2024, 1, 1, 16, 10, 0, 0, jst)}
+	h.agg = newEventAggregator(settle, maxWait, flushOnFinal, func(eq JMAQuake) {
+		h.emitted = append(h.emitted, eq)
+	})
+	h.agg.now = func() time.Time { return h.now }
+	h.agg.afterFunc = func(d time.Duration, f func()) stopper {
+		t := &fakeTimer{delay: d, fn: f}
+		h.timers = append(h.timers, t)
+		return t
+	}
+	return h
+}
+
+// Fire the most recently scheduled timer
+func (h *aggregatorHarness) fireLast() {
+	h.timers[len(h.timers)-1].fn()
+}
+
+func report(id, issueType string, maxScale int, hypocenter *Hypocenter, points ...Point) JMAQuake {
+	var eq JMAQuake
+	eq.ID = id
+	eq.Issue.Type = issueType
+	eq.Earthquake.Time = "2024/01/01 16:10:00"
+	eq.Earthquake.MaxScale = maxScale
+	eq.Earthquake.Hypocenter = hypocenter
+	eq.Points = points
+	return eq
+}
+
+var noHypocenter = &Hypocenter{Latitude: -200, Longitude: -200, Depth: -1, Magnitude: -1}
+
+func TestAggregatorSingleReport(t *testing.T) {
+	h := newHarness(30*time.Second, 2*time.Minute, false)
+	h.agg.Add(report("a", "ScalePrompt", 40, noHypocenter))
+	if len(h.emitted) != 0 {
+		t.Fatal("report emitted before the settle period")
+	}
+	if len(h.timers) != 1 || h.timers[0].delay != 30*time.Second {
+		t.Fatalf("expected one 30s settle timer, got %+v", h.timers)
+	}
+	h.fireLast()
+	if len(h.emitted) != 1 || h.emitted[0].ID != "a" {
+		t.Fatalf("emitted %+v, want report a", h.emitted)
+	}
+}
+
+func TestAggregatorMergesReportSequence(t *testing.T) {
+	h := newHarness(30*time.Second, 2*time.Minute, false)
+	hypo := &Hypocenter{Name: "石川県能登地方", Latitude: 37.5, Longitude: 137.2, Depth: 10, Magnitude: 7.6}
+
+	h.agg.Add(report("prompt", "ScalePrompt", 70, noHypocenter, Point{Pref: "石川県", Scale: 70, IsArea: true}))
+	h.now = h.now.Add(10 * time.Second)
+	h.agg.Add(report("dest", "Destination", -1, hypo))
+	h.now = h.now.Add(10 * time.Second)
+	h.agg.Add(report("detail", "DetailScale", 70, noHypocenter,
+		Point{Pref: "石川県", Addr: "志賀町", Scale: 70},
+		Point{Pref: "新潟県", Addr: "長岡市", Scale: 60},
+	))
+
+	if len(h.emitted) != 0 {
+		t.Fatal("reports emitted before the settle period")
+	}
+	// Every report restarts the settle timer and stops the previous one
+	if len(h.timers) != 3 {
+		t.Fatalf("scheduled %d timers, want 3", len(h.timers))
+	}
+	for i, timer := range h.timers[:2] {
+		if !timer.stopped {
+			t.Errorf("timer %d was not stopped when a newer report arrived", i)
+		}
+	}
+	// A superseded timer firing late does nothing
+	h.timers[0].fn()
+	if len(h.emitted) != 0 {
+		t.Fatal("a stale timer flushed the quake")
+	}
+
+	h.fireLast()
+	if len(h.emitted) != 1 {
+		t.Fatalf("emitted %d alerts, want 1", len(h.emitted))
+	}
+	got := h.emitted[0]
+	if got.ID != "detail" {
+		t.Errorf("base report %q, want the DetailScale report", got.ID)
+	}
+	if got.Earthquake.MaxScale != 70 {
+		t.Errorf("MaxScale = %d, want 70", got.Earthquake.MaxScale)
+	}
+	if got.Earthquake.Hypocenter == nil || got.Earthquake.Hypocenter.Name != "石川県能登地方" {
+		t.Errorf("hypocenter %+v, want the one from the Destination report", got.Earthquake.Hypocenter)
+	}
+	if len(got.Points) != 2 {
+		t.Errorf("%d points, want the 2 detailed ones", len(got.Points))
+	}
+
+	// The quake is forgotten after the flush: a late report starts over
+	h.agg.Add(report("late", "DetailScale", 70, hypo))
+	h.fireLast()
+	if len(h.emitted) != 2 || h.emitted[1].ID != "late" {
+		t.Errorf("late report not emitted on its own: %+v", h.emitted)
+	}
+}
+
+func TestAggregatorCorrectionWins(t *testing.T) {
+	h := newHarness(30*time.Second, 2*time.Minute, false)
+	hypo := &Hypocenter{Name: "千葉県北西部", Depth: 60, Magnitude: 5.0}
+	h.agg.Add(report("detail", "DetailScale", 50, hypo, Point{Pref: "千葉県", Addr: "千葉中央区", Scale: 50}))
+	correction := report("fix", "ScalePrompt", 45, noHypocenter)
+	correction.Issue.Correct = "ScaleOnly"
+	h.agg.Add(correction)
+	h.fireLast()
+
+	if len(h.emitted) != 1 {
+		t.Fatalf("emitted %d alerts, want 1", len(h.emitted))
+	}
+	got := h.emitted[0]
+	if got.ID != "fix" || got.Earthquake.MaxScale != 45 {
+		t.Errorf("got %s with MaxScale %d, want the correction with 45", got.ID, got.Earthquake.MaxScale)
+	}
+	// Information the correction lacks is kept
+	if got.Earthquake.Hypocenter.Name != "千葉県北西部" || len(got.Points) != 1 {
+		t.Errorf("correction dropped earlier data: %+v", got)
+	}
+}
+
+func TestAggregatorLessDetailedReportDoesNotReplace(t *testing.T) {
+	h := newHarness(30*time.Second, 2*time.Minute, false)
+	h.agg.Add(report("detail", "DetailScale", 50, noHypocenter, Point{Pref: "千葉県", Scale: 50}))
+	h.agg.Add(report("prompt", "ScalePrompt", 40, noHypocenter))
+	h.fireLast()
+	if got := h.emitted[0]; got.ID != "detail" || got.Earthquake.MaxScale != 50 {
+		t.Errorf("got %s with MaxScale %d, want the DetailScale report", got.ID, got.Earthquake.MaxScale)
+	}
+}
+
+func TestAggregatorMaxWaitCapsSettle(t *testing.T) {
+	h := newHarness(30*time.Second, 45*time.Second, false)
+	h.agg.Add(report("a", "ScalePrompt", 30, noHypocenter))
+	h.now = h.now.Add(20 * time.Second)
+	h.agg.Add(report("b", "Destination", -1, noHypocenter))
+	if got := h.timers[1].delay; got != 25*time.Second {
+		t.Errorf("second timer %v, want the 25s left of the maximum wait", got)
+	}
+	// Past the maximum wait, a report flushes at once
+	h.now = h.now.Add(30 * time.Second)
+	h.agg.Add(report("c", "ScaleAndDestination", 30, noHypocenter))
+	if len(h.emitted) != 1 {
+		t.Fatalf("emitted %d alerts, want an immediate flush", len(h.emitted))
+	}
+}
+
+func TestAggregatorFlushOnFinal(t *testing.T) {
+	h := newHarness(30*time.Second, 30*time.Second, true)
+	h.agg.Add(report("prompt", "ScalePrompt", 40, noHypocenter))
+	h.agg.Add(report("detail", "DetailScale", 40, noHypocenter, Point{Pref: "東京都", Scale: 40}))
+	if len(h.emitted) != 1 || h.emitted[0].ID != "detail" {
+		t.Fatalf("DetailScale did not flush at once: %+v", h.emitted)
+	}
+	if !h.timers[0].stopped {
+		t.Error("settle timer left running after the flush")
+	}
+}
+
+func TestAggregatorSeparatesQuakes(t *testing.T) {
+	h := newHarness(30*time.Second, 2*time.Minute, false)
+	first := report("a", "ScalePrompt", 30, noHypocenter)
+	second := report("b", "ScalePrompt", 20, noHypocenter)
+	second.Earthquake.Time = "2024/01/01 16:12:00"
+	h.agg.Add(first)
+	h.agg.Add(second)
+	h.agg.FlushAll()
+	if len(h.emitted) != 2 {
+		t.Errorf("emitted %d alerts, want one per quake", len(h.emitted))
+	}
+}
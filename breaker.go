@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Circuit Breaker (per webhook host, per Telegram chat)
+//────────────────────────────
+
+// State of one host or chat: after BREAKER_THRESHOLD consecutive failures the circuit
+// opens and sends are refused for BREAKER_COOLDOWN. A single probe is then let
+// through; its outcome closes the circuit again or restarts the cooldown.
+type breakerState struct {
+	Failures  int
+	OpenUntil time.Time
+	Probing   bool
+}
+
+var (
+	breakerMu sync.Mutex
+	breakers  = make(map[string]*breakerState)
+)
+
+// Webhooks share a breaker per host, since an outage takes all of them down
+func breakerHost(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+	return u.Host
+}
+
+// Whether a request may be sent to the host or chat named by key now
+func breakerAllow(key string) bool {
+	if currentEnv().BreakerThreshold <= 0 {
+		return true
+	}
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	b := breakers[key]
+	if b == nil || b.OpenUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.OpenUntil) || b.Probing {
+		return false
+	}
+	b.Probing = true
+	return true
+}
+
+// Record the outcome of a request. Only outages count as failures: network
+// errors, rate limiting and server errors; other 4xx are the request's fault.
+func breakerRecord(key string, status int, err error) {
+	env := currentEnv()
+	if env.BreakerThreshold <= 0 {
+		return
+	}
+	failed := err != nil || status == http.StatusTooManyRequests || status >= 500
+
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	b := breakers[key]
+	if b == nil {
+		b = &breakerState{}
+		breakers[key] = b
+	}
+	wasOpen := !b.OpenUntil.IsZero()
+	b.Probing = false
+	if !failed {
+		if wasOpen {
+			withFields(logFields{"host": key}).info("circuit_closed", "Circuit closed, sends to %s resumed", key)
+		}
+		*b = breakerState{}
+		return
+	}
+	b.Failures++
+	if wasOpen || b.Failures >= env.BreakerThreshold {
+		b.OpenUntil = time.Now().Add(env.BreakerCooldown)
+		if !wasOpen {
+			withFields(logFields{"host": key, "failures": b.Failures}).warn("circuit_opened", "Circuit opened after %d failures, pausing sends to %s for %v", b.Failures, key, env.BreakerCooldown)
+		}
+	}
+}
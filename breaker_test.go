@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Start from no breaker state, and leave none behind
+func resetBreakers(t *testing.T) {
+	t.Helper()
+	breakerMu.Lock()
+	breakers = make(map[string]*breakerState)
+	breakerMu.Unlock()
+	t.Cleanup(func() {
+		breakerMu.Lock()
+		breakers = make(map[string]*breakerState)
+		breakerMu.Unlock()
+	})
+}
+
+func TestHalfOpenProbeSurvivesRateLimit(t *testing.T) {
+	withEnv(t, Env{BreakerThreshold: 1, BreakerCooldown: time.Minute, RateLimitRetries: 2, WebhookTimeout: 5 * time.Second})
+	resetBreakers(t)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"retry_after": 0.01}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	urlStr := srv.URL + "/api/webhooks/1/token"
+
+	// Cooldown over: the next send is the probe
+	breakers[breakerHost(urlStr)] = &breakerState{Failures: 1, OpenUntil: time.Now().Add(-time.Second)}
+
+	if !sendWebhook(discordNotifier{}, MessageBody{EventID: "probe"}, urlStr, false) {
+		t.Fatalf("probe was not delivered after the 429 (%d requests)", requests)
+	}
+	if requests != 2 {
+		t.Errorf("server got %d requests, want 2", requests)
+	}
+	if b := breakers[breakerHost(urlStr)]; b != nil && (b.Probing || !b.OpenUntil.IsZero()) {
+		t.Errorf("breaker left as %+v, want closed", *b)
+	}
+	if !breakerAllow(breakerHost(urlStr)) {
+		t.Error("breaker refuses sends after a successful probe")
+	}
+}
+
+func TestBreakerStates(t *testing.T) {
+	type step struct {
+		status int
+		err    bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+		allow     bool
+	}{
+		{"disabled", 0, []step{{500, false}, {500, false}, {500, false}}, true},
+		{"below threshold", 3, []step{{500, false}, {0, true}}, true},
+		{"opens at threshold", 3, []step{{500, false}, {0, true}, {429, false}}, false},
+		{"success resets count", 3, []step{{500, false}, {500, false}, {204, false}, {500, false}}, true},
+		{"client errors do not count", 2, []step{{400, false}, {404, false}, {401, false}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnv(t, Env{BreakerThreshold: tt.threshold, BreakerCooldown: time.Minute})
+			resetBreakers(t)
+			for _, s := range tt.steps {
+				var err error
+				if s.err {
+					err = errors.New("connection refused")
+				}
+				breakerRecord("discord.com", s.status, err)
+			}
+			if got := breakerAllow("discord.com"); got != tt.allow {
+				t.Errorf("breakerAllow = %v, want %v", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	withEnv(t, Env{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	resetBreakers(t)
+	expire := func() { breakers["host"].OpenUntil = time.Now().Add(-time.Second) }
+
+	breakerRecord("host", 503, nil)
+	if breakerAllow("host") {
+		t.Fatal("open circuit allowed a send during the cooldown")
+	}
+	if !breakerAllow("other") {
+		t.Fatal("another host was refused")
+	}
+
+	expire()
+	if !breakerAllow("host") {
+		t.Fatal("no probe after the cooldown")
+	}
+	if breakerAllow("host") {
+		t.Fatal("a second send was let through while probing")
+	}
+	// A failed probe restarts the cooldown
+	breakerRecord("host", 503, nil)
+	if breakerAllow("host") {
+		t.Fatal("failed probe did not reopen the circuit")
+	}
+
+	expire()
+	if !breakerAllow("host") {
+		t.Fatal("no probe after the second cooldown")
+	}
+	breakerRecord("host", 204, nil)
+	if !breakerAllow("host") || !breakerAllow("host") {
+		t.Fatal("successful probe did not close the circuit")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+//────────────────────────────
+// Embed Color Selection
+//────────────────────────────
+
+// Color of embeds without an intensity, and of COLOR_SCHEME=classic
+const defaultEmbedColor = 2264063
+
+// Default palette: green for weak shaking, yellow to orange for moderate,
+// red for 6 lower and above
+var severityColors = map[int]int{
+	10: 0x57F287,
+	20: 0x57F287,
+	30: 0x9BD94C,
+	40: 0xFEE75C,
+	45: 0xFFC107,
+	50: 0xFF9800,
+	55: 0xFF6F00,
+	60: 0xED4245,
+	70: 0xB00020,
+}
+
+// JMA's official intensity palette, as used on its maps and by NHK
+var jmaColors = map[int]int{
+	10: 0xF2F2FF,
+	20: 0x00AAFF,
+	30: 0x0041FF,
+	40: 0xFAE696,
+	45: 0xFFE600,
+	50: 0xFF9900,
+	55: 0xFF2800,
+	60: 0xA50021,
+	70: 0xB40068,
+}
+
+var colorSchemes = map[string]map[int]int{
+	"severity": severityColors,
+	"jma":      jmaColors,
+	"classic":  {},
+}
+
+// Parse EMBED_COLORS ("50:#FF9900,70:800080") into scale code → color
+func parseEmbedColors(value string) map[int]int {
+	var colors map[int]int
+	for _, entry := range parseList(value) {
+		code, hex, found := strings.Cut(entry, ":")
+		scale, err := strconv.Atoi(strings.TrimSpace(code))
+		hex = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(hex), "#"), "0x")
+		color, hexErr := strconv.ParseUint(hex, 16, 32)
+		if !found || err != nil || hexErr != nil || len(hex) != 6 {
+			logWarn("config", "Invalid EMBED_COLORS entry %q, expected scale:hexcolor", entry)
+			continue
+		}
+		if colors == nil {
+			colors = make(map[int]int)
+		}
+		colors[scale] = int(color)
+	}
+	return colors
+}
+
+// Pick the embed color for a max scale from COLOR_SCHEME (severity by
+// default), with EMBED_COLORS entries taking precedence. Codes missing from
+// the palette use the color of the nearest lower listed scale. With DISABLE_COLOR
+// it returns 0, which leaves the color out of the embed entirely.
+func embedColor(maxScale int) int {
+	env := currentEnv()
+	if env.DisableColor {
+		return 0
+	}
+	// validateEnv rejects unknown schemes, so only an unset one is missing
+	base, ok := colorSchemes[env.ColorScheme]
+	if !ok {
+		base = severityColors
+	}
+	palette := make(map[int]int, len(base)+len(env.EmbedColors))
+	for scale, c := range base {
+		palette[scale] = c
+	}
+	for scale, c := range env.EmbedColors {
+		palette[scale] = c
+	}
+	best, color := -1, defaultEmbedColor
+	for scale, c := range palette {
+		if scale <= maxScale && scale > best {
+			best, color = scale, c
+		}
+	}
+	return color
+}
@@ -0,0 +1,37 @@
+package main
+
+import "fmt"
+
+//────────────────────────────
+// Corrected Reports (issue.correct)
+//────────────────────────────
+
+// Whether JMA issued the report as a correction of an earlier one. "None"
+// and "Unknown" are what uncorrected reports carry.
+func isCorrection(eq JMAQuake) bool {
+	switch eq.Issue.Correct {
+	case "", "None", "Unknown":
+		return false
+	}
+	return true
+}
+
+// Readable text for a JMA correction code (ScaleOnly, DestinationOnly,
+// ScaleAndDestination); unknown codes are shown as is
+func correctionText(code string) string {
+	if s := tr("correct_" + code); s != "" {
+		return s
+	}
+	return code
+}
+
+// Mark an earthquake embed as correcting the earlier report of the same
+// quake, which is referred to by its origin time
+func markCorrection(body *MessageBody, eq JMAQuake) {
+	origin := eq.Earthquake.Time
+	if t, err := displayTime(origin); err == nil {
+		origin = t.Format("2006/01/02 15:04:05")
+	}
+	body.Title = fmt.Sprintf(tr("revised_title"), body.Title)
+	body.Description = fmt.Sprintf(tr("correction_note"), correctionText(eq.Issue.Correct), origin) + body.Description
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// JMA Feed Cross-check (JMA_CROSSCHECK)
+//────────────────────────────
+
+const jmaListURL = "https://www.jma.go.jp/bosai/quake/data/list.json"
+
+const (
+	// How long a quake may be known to only one source before it counts as missing
+	crossCheckGrace = 5 * time.Minute
+	// Only recent quakes are compared
+	crossCheckWindow = time.Hour
+	// Origin times of the same quake may differ slightly between the sources
+	crossCheckTolerance = 2 * time.Minute
+)
+
+// One report in JMA's list.json
+type jmaListEntry struct {
+	EventID      string `json:"eid"`
+	Title        string `json:"ttl"`
+	OriginTime   string `json:"at"`
+	AreaName     string `json:"anm"`
+	Magnitude    string `json:"mag"`
+	MaxIntensity string `json:"maxi"`
+}
+
+// JMA intensity strings and the matching P2PQuake scale codes, weakest first
+var jmaIntensityCodes = []struct {
+	Label string
+	Code  int
+}{
+	{"1", 10}, {"2", 20}, {"3", 30}, {"4", 40}, {"5-", 45},
+	{"5+", 50}, {"6-", 55}, {"6+", 60}, {"7", 70},
+}
+
+// Position of a scale code in the intensity ladder (-1 when not a JMA intensity)
+func intensityRank(code int) int {
+	for i, c := range jmaIntensityCodes {
+		if c.Code == code {
+			return i
+		}
+	}
+	return -1
+}
+
+func jmaScaleCode(label string) int {
+	for _, c := range jmaIntensityCodes {
+		if c.Label == label {
+			return c.Code
+		}
+	}
+	return 0
+}
+
+// A quake as known to one source: origin time and highest intensity
+type observedQuake struct {
+	Origin   time.Time
+	MaxScale int
+	// For JMA: the adapted report, fed to the pipeline when P2PQuake missed it
+	Quake JMAQuake
+}
+
+var (
+	crossMu      sync.Mutex
+	p2pObserved  = make(map[string]observedQuake)
+	crossAlerted = make(map[string]bool)
+)
+
+// Remember a quake delivered by P2PQuake for the cross-check
+func noteP2PQuake(eq JMAQuake) {
+	if !currentEnv().JMACrossCheck || eq.Earthquake.MaxScale <= 0 {
+		return
+	}
+	origin, err := time.ParseInLocation("2006/01/02 15:04:05", eq.Earthquake.Time, jst)
+	if err != nil {
+		return
+	}
+	crossMu.Lock()
+	defer crossMu.Unlock()
+	prev := p2pObserved[eq.Earthquake.Time]
+	if eq.Earthquake.MaxScale > prev.MaxScale {
+		p2pObserved[eq.Earthquake.Time] = observedQuake{Origin: origin, MaxScale: eq.Earthquake.MaxScale}
+	}
+}
+
+func fetchJMAList() ([]jmaListEntry, error) {
+	resp, err := apiClient.Get(jmaListURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("JMA list request failed (HTTP %d)", resp.StatusCode)
+	}
+	var entries []jmaListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding JMA list: %w", err)
+	}
+	return entries, nil
+}
+
+// Adapt a JMA list entry to the P2PQuake shape, so it can go through
+// handleEarthquake. There are no observation points and no coordinates.
+func jmaToQuake(e jmaListEntry, origin time.Time, code int) JMAQuake {
+	var eq JMAQuake
+	eq.ID = "jma-" + e.EventID
+	eq.Issue = Issue{Source: "気象庁", Type: "ScalePrompt", Time: origin.Format("2006/01/02 15:04:05")}
+	eq.Earthquake = Earthquake{
+		Time:       origin.Format("2006/01/02 15:04:05"),
+		MaxScale:   code,
+		Hypocenter: &Hypocenter{Name: e.AreaName, Latitude: -200, Longitude: -200, Depth: -1, Magnitude: -1},
+	}
+	if mag, err := strconv.ParseFloat(e.Magnitude, 64); err == nil {
+		eq.Earthquake.Hypocenter.Magnitude = mag
+	}
+	return eq
+}
+
+// Group the JMA reports by event, keeping the highest intensity reported
+func jmaQuakes(entries []jmaListEntry) map[string]observedQuake {
+	quakes := make(map[string]observedQuake)
+	for _, e := range entries {
+		code := jmaScaleCode(e.MaxIntensity)
+		if e.EventID == "" || code == 0 {
+			continue
+		}
+		origin, err := time.Parse(time.RFC3339, e.OriginTime)
+		if err != nil {
+			continue
+		}
+		if prev, ok := quakes[e.EventID]; !ok || code > prev.MaxScale {
+			quakes[e.EventID] = observedQuake{Origin: origin, MaxScale: code, Quake: jmaToQuake(e, origin.In(jst), code)}
+		}
+	}
+	return quakes
+}
+
+// Report a discrepancy once
+func crossAlert(key, format string, args ...interface{}) {
+	if crossAlerted[key] {
+		return
+	}
+	crossAlerted[key] = true
+	logWarn("crosscheck", "WARNING: cross-check: "+format, args...)
+}
+
+// Compare the recent quakes of both sources. Returns the JMA quakes that
+// P2PQuake did not deliver.
+func crossCheck(jma map[string]observedQuake, now time.Time) []JMAQuake {
+	crossMu.Lock()
+	defer crossMu.Unlock()
+	for k, q := range p2pObserved {
+		if now.Sub(q.Origin) > crossCheckWindow {
+			delete(p2pObserved, k)
+		}
+	}
+
+	var missed []JMAQuake
+	matched := make(map[string]bool)
+	ids := make([]string, 0, len(jma))
+	for id := range jma {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		q := jma[id]
+		age := now.Sub(q.Origin)
+		if age > crossCheckWindow || age < crossCheckGrace {
+			continue
+		}
+		key, p2p, found := "", observedQuake{}, false
+		for k, p := range p2pObserved {
+			d := p.Origin.Sub(q.Origin)
+			if d < 0 {
+				d = -d
+			}
+			if d <= crossCheckTolerance {
+				key, p2p, found = k, p, true
+				break
+			}
+		}
+		label, _ := parseScale(q.MaxScale)
+		if !found {
+			if !crossAlerted["missed:"+id] {
+				missed = append(missed, q.Quake)
+			}
+			crossAlert("missed:"+id, "JMA reported a quake at %s (max intensity %s) that P2PQuake did not deliver",
+				q.Origin.In(displayLocation()).Format("2006/01/02 15:04"), label)
+			continue
+		}
+		matched[key] = true
+		if diff := intensityRank(q.MaxScale) - intensityRank(p2p.MaxScale); diff >= 2 || diff <= -2 {
+			p2pLabel, _ := parseScale(p2p.MaxScale)
+			crossAlert("scale:"+id, "max intensity of the quake at %s differs: JMA %s, P2PQuake %s",
+				q.Origin.In(displayLocation()).Format("2006/01/02 15:04"), label, p2pLabel)
+		}
+	}
+	for k, p := range p2pObserved {
+		if matched[k] || now.Sub(p.Origin) < crossCheckGrace {
+			continue
+		}
+		crossAlert("unknown:"+k, "P2PQuake delivered a quake at %s that is not in the JMA feed", k)
+	}
+	return missed
+}
+
+// Poll JMA's feed every JMA_CROSSCHECK_INTERVAL and compare it with what
+// P2PQuake delivered. With JMA_FORWARD_MISSED, quakes only JMA knows about
+// are posted through the regular pipeline.
+func runCrossCheck(isDev bool) {
+	env := currentEnv()
+	logInfo("startup", "Cross-checking against %s every %v", jmaListURL, env.JMACrossCheckInterval)
+	for {
+		entries, err := fetchJMAList()
+		if err != nil {
+			logThrottled("error", "crosscheck", "Cross-check error: %v", err)
+		} else {
+			for _, eq := range crossCheck(jmaQuakes(entries), time.Now()) {
+				if currentEnv().JMAForwardMissed {
+					handleEarthquake(eq, isDev)
+				}
+			}
+		}
+		time.Sleep(env.JMACrossCheckInterval)
+	}
+}
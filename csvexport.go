@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//────────────────────────────
+// CSV Export of Handled Events
+//────────────────────────────
+
+var csvMu sync.Mutex
+
+var csvHeader = []string{"time", "id", "magnitude", "depth", "max_intensity", "prefectures"}
+
+// Append an event to CSV_FILE (no-op when unset), writing the header to a new file
+func appendEventCSV(eq JMAQuake, groups []PointGroup) {
+	env := currentEnv()
+	if env.CSVFile == "" {
+		return
+	}
+	magnitude, depth := "", ""
+	if h := eq.Earthquake.Hypocenter; h != nil {
+		if h.Magnitude > 0 {
+			magnitude = fmt.Sprintf("%.1f", h.Magnitude)
+		}
+		if h.Depth >= 0 && h.Name != "" {
+			depth = fmt.Sprintf("%.0f", h.Depth)
+		}
+	}
+	maxScale, _ := parseScale(eq.Earthquake.MaxScale)
+	var prefectures []string
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			prefectures = append(prefectures, fmt.Sprintf("%s:%s", region, g.ScaleStr))
+		}
+	}
+	row := []string{eq.Earthquake.Time, eq.ID, magnitude, depth, maxScale, strings.Join(prefectures, "; ")}
+
+	csvMu.Lock()
+	defer csvMu.Unlock()
+	f, err := os.OpenFile(env.CSVFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logError("csv", "Error opening CSV file: %v", err)
+		return
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		_ = w.Write(csvHeader)
+	}
+	_ = w.Write(row)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logError("csv", "Error writing CSV file: %v", err)
+	}
+}
+
+// A file uploaded along with the message
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Every observation point as CSV, attached to large events (ATTACH_POINTS_THRESHOLD)
+func pointsCSV(points []Point) (Attachment, error) {
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"pref", "addr", "scale", "intensity", "is_area"})
+	for _, p := range points {
+		label, _ := parseScale(p.Scale)
+		w.Write([]string{p.Pref, p.Addr, strconv.Itoa(p.Scale), label, strconv.FormatBool(p.IsArea)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{Name: "points.csv", Data: []byte(buf.String())}, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+//────────────────────────────
+// Dead Webhook Detection
+//────────────────────────────
+
+// Consecutive 401/404 responses after which a webhook is considered deleted
+const deadWebhookThreshold = 3
+
+var (
+	deadMu       sync.Mutex
+	authFailures = make(map[string]int)
+	deadWebhooks = make(map[string]bool)
+)
+
+// Whether the webhook was found dead; it is skipped until the process restarts
+func isDeadWebhook(urlStr string) bool {
+	deadMu.Lock()
+	defer deadMu.Unlock()
+	return deadWebhooks[urlStr]
+}
+
+// The destinations whose webhook was not found dead. Telegram chats are not
+// tracked and always count as live.
+func liveDestinations(dests []Destination) []Destination {
+	var live []Destination
+	for _, d := range dests {
+		if d.Type == "telegram" || !isDeadWebhook(d.URL) {
+			live = append(live, d)
+		}
+	}
+	return live
+}
+
+// Track 401/404 responses per webhook. Any other status resets the count.
+// Once the threshold is reached the operator is alerted exactly once.
+func recordWebhookStatus(urlStr string, status int) {
+	deadMu.Lock()
+	if status != http.StatusUnauthorized && status != http.StatusNotFound {
+		delete(authFailures, urlStr)
+		deadMu.Unlock()
+		return
+	}
+	authFailures[urlStr]++
+	if authFailures[urlStr] < deadWebhookThreshold || deadWebhooks[urlStr] {
+		deadMu.Unlock()
+		return
+	}
+	deadWebhooks[urlStr] = true
+	deadMu.Unlock()
+
+	masked := maskWebhookURL(urlStr)
+	withFields(logFields{"webhook": masked, "status": status}).error("webhook_dead", "ERROR: webhook %s returned HTTP %d %d times in a row; it was probably deleted and will no longer be used", masked, status, deadWebhookThreshold)
+	if currentEnv().DeadWebhookAlert {
+		go alertDeadWebhook(masked, status)
+	}
+}
+
+// Report the dead webhook through the first configured webhook that still works
+func alertDeadWebhook(masked string, status int) {
+	env := currentEnv()
+	body := MessageBody{
+		Title:       tr("dead_webhook_title"),
+		Description: fmt.Sprintf(tr("dead_webhook"), masked, status),
+		Tag:         nextSendTag(),
+	}
+	dests := append(configuredDestinations(env), webhookDestinations(env.SinkType, env.DrillWebhookURL)...)
+	for _, d := range dests {
+		if d.Type == "telegram" || isDeadWebhook(d.URL) {
+			continue
+		}
+		if sendToDestination(d, body, false) {
+			return
+		}
+	}
+	logError("webhook_dead", "ERROR: no working webhook left to report the dead webhook")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//────────────────────────────
+// Dead Letters (DEAD_LETTER_FILE)
+//────────────────────────────
+
+// A message that no destination accepted, kept for redelivery
+type deadLetter struct {
+	Failed       time.Time     `json:"failed"`
+	EventID      string        `json:"eventId,omitempty"`
+	Destinations []Destination `json:"destinations"`
+	Mention      bool          `json:"mention,omitempty"`
+	Body         MessageBody   `json:"body"`
+	// Parts of the message that its JSON form leaves out
+	ThreadName  string      `json:"threadName,omitempty"`
+	Prefectures []string    `json:"prefectures,omitempty"`
+	MaxScale    int         `json:"maxScale,omitempty"`
+	Tsunami     bool        `json:"tsunami,omitempty"`
+	Magnitude   float64     `json:"magnitude,omitempty"`
+	Attachment  *Attachment `json:"attachment,omitempty"`
+}
+
+func newDeadLetter(body MessageBody, dests []Destination, mention bool) deadLetter {
+	return deadLetter{
+		Failed:       time.Now(),
+		EventID:      body.EventID,
+		Destinations: dests,
+		Mention:      mention,
+		Body:         body,
+		ThreadName:   body.ThreadName,
+		Prefectures:  body.Prefectures,
+		MaxScale:     body.MaxScale,
+		Tsunami:      body.Tsunami,
+		Magnitude:    body.Magnitude,
+		Attachment:   body.Attachment,
+	}
+}
+
+// The message as it was first sent
+func (l deadLetter) message() MessageBody {
+	body := l.Body
+	body.EventID = l.EventID
+	body.ThreadName = l.ThreadName
+	body.Prefectures = l.Prefectures
+	body.MaxScale = l.MaxScale
+	body.Tsunami = l.Tsunami
+	body.Magnitude = l.Magnitude
+	body.Attachment = l.Attachment
+	return body
+}
+
+var (
+	deadLetterMu sync.Mutex
+	// Set while a redelivery runs, so that successes do not start another
+	redelivering atomic.Bool
+)
+
+// Append a message that failed everywhere to DEAD_LETTER_FILE. The file holds
+// one JSON entry per line; past DEAD_LETTER_MAX_BYTES the oldest are dropped.
+// Dead webhooks will never accept it, so they are left out, and a message
+// with no other destination is dropped.
+func saveDeadLetter(letter deadLetter) {
+	env := currentEnv()
+	if env.DeadLetterFile == "" {
+		return
+	}
+	letter.Destinations = liveDestinations(letter.Destinations)
+	if len(letter.Destinations) == 0 {
+		withFields(logFields{"event_id": letter.EventID}).warn("dead_letter", "All destinations of the message are dead webhooks, not keeping it for redelivery")
+		return
+	}
+	line, err := json.Marshal(letter)
+	if err != nil {
+		logError("dead_letter", "Error encoding dead letter: %v", err)
+		return
+	}
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	lines, err := readDeadLetters(env.DeadLetterFile)
+	if err != nil {
+		logError("dead_letter", "Error reading %s: %v", env.DeadLetterFile, err)
+		return
+	}
+	lines = append(lines, line)
+	dropped := 0
+	for len(lines) > 1 && deadLettersSize(lines) > env.DeadLetterMaxBytes {
+		lines = lines[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		logWarn("dead_letter", "%s is full, dropped the %d oldest message(s)", env.DeadLetterFile, dropped)
+	}
+	if err := writeDeadLetters(env.DeadLetterFile, lines); err != nil {
+		logError("dead_letter", "Error writing %s: %v", env.DeadLetterFile, err)
+		return
+	}
+	withFields(logFields{"event_id": letter.EventID}).warn("dead_letter", "All destinations failed, message kept in %s for redelivery", env.DeadLetterFile)
+}
+
+// Start redelivering the dead letters in the background, unless that is
+// already under way. Called at startup and whenever a send succeeds again.
+func redeliverDeadLetters() {
+	if currentEnv().DeadLetterFile == "" || !redelivering.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer redelivering.Store(false)
+		redeliver()
+	}()
+}
+
+func redeliver() {
+	env := currentEnv()
+	deadLetterMu.Lock()
+	lines, err := readDeadLetters(env.DeadLetterFile)
+	if err == nil && len(lines) > 0 {
+		// Taken out of the file while they are sent; failures are put back
+		err = writeDeadLetters(env.DeadLetterFile, nil)
+	}
+	deadLetterMu.Unlock()
+	if err != nil {
+		logError("dead_letter", "Error taking messages from %s: %v", env.DeadLetterFile, err)
+		return
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	delivered := 0
+	var failed []deadLetter
+	for _, line := range lines {
+		var letter deadLetter
+		if err := json.Unmarshal(line, &letter); err != nil {
+			logWarn("dead_letter", "Dropping unreadable dead letter: %v", err)
+			continue
+		}
+		if resendDeadLetter(letter) {
+			delivered++
+		} else {
+			failed = append(failed, letter)
+		}
+	}
+	for _, letter := range failed {
+		saveDeadLetter(letter)
+	}
+	logInfo("dead_letter", "Redelivered %d of %d kept message(s)", delivered, delivered+len(failed))
+}
+
+// Send a dead letter to the destinations it failed on, noting the delay in
+// its footer. It counts as delivered once any destination accepts it.
+func resendDeadLetter(letter deadLetter) bool {
+	body := letter.message()
+	body.Tag = nextSendTag()
+	body.Dedup = "redelivered"
+	note := fmt.Sprintf(tr("redelivered"), letter.Failed.In(displayLocation()).Format("2006/01/02 15:04:05"))
+	if body.Footer != nil && body.Footer.Text != "" {
+		note = body.Footer.Text + " · " + note
+	}
+	body.Footer = &MessageFooter{Text: note}
+
+	ok := false
+	for _, d := range letter.Destinations {
+		if sendToDestination(d, body, letter.Mention) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// The entries of the dead letter file (none when it does not exist)
+func readDeadLetters(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func deadLettersSize(lines [][]byte) int {
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+	return size
+}
+
+// Replace the dead letter file atomically. It may hold webhook URLs and bot
+// tokens, which is why it keeps the owner-only mode of the temporary file.
+func writeDeadLetters(path string, lines [][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".deadletter-*")
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	_, err = tmp.WriteString(sb.String())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
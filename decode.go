@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//────────────────────────────
+// Lenient Earthquake Decoding
+//────────────────────────────
+
+// Decode an earthquake message field by field, so that a malformed field only
+// loses that field instead of the whole alert. The names of the fields that
+// could not be decoded are returned alongside the quake.
+func decodeQuake(message []byte) (JMAQuake, []string, error) {
+	var quake JMAQuake
+	var failed []string
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(message, &raw); err != nil {
+		return quake, nil, err
+	}
+	decode := func(fields map[string]json.RawMessage, prefix, key string, v interface{}) {
+		if r, ok := fields[key]; ok {
+			if err := json.Unmarshal(r, v); err != nil {
+				failed = append(failed, prefix+key)
+			}
+		}
+	}
+
+	decode(raw, "", "id", &quake.ID)
+	// Only a fallback, so a malformed "_id" is not worth reporting
+	_ = json.Unmarshal(raw["_id"], &quake.LegacyID)
+	quake.fillID()
+	decode(raw, "", "code", &quake.Code)
+	decode(raw, "", "time", &quake.Time)
+	decode(raw, "", "issue", &quake.Issue)
+	decode(raw, "", "comments", &quake.Comments)
+	decode(raw, "", "test", &quake.Test)
+
+	var earthquake map[string]json.RawMessage
+	decode(raw, "", "earthquake", &earthquake)
+	decode(earthquake, "earthquake.", "time", &quake.Earthquake.Time)
+	decode(earthquake, "earthquake.", "maxScale", &quake.Earthquake.MaxScale)
+	decode(earthquake, "earthquake.", "domesticTsunami", &quake.Earthquake.DomesticTsunami)
+	decode(earthquake, "earthquake.", "foreignTsunami", &quake.Earthquake.ForeignTsunami)
+	var hypocenter Hypocenter
+	if _, ok := earthquake["hypocenter"]; ok {
+		before := len(failed)
+		decode(earthquake, "earthquake.", "hypocenter", &hypocenter)
+		if len(failed) == before {
+			quake.Earthquake.Hypocenter = &hypocenter
+		}
+	}
+
+	var points []json.RawMessage
+	decode(raw, "", "points", &points)
+	for i, r := range points {
+		var p Point
+		if err := json.Unmarshal(r, &p); err != nil {
+			failed = append(failed, fmt.Sprintf("points[%d]", i))
+			continue
+		}
+		quake.Points = append(quake.Points, p)
+	}
+	return quake, failed, nil
+}
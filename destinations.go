@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+)
+
+//────────────────────────────
+// Output Destinations (DESTINATIONS)
+//────────────────────────────
+
+// One place messages are posted to: a webhook in one of the notifier formats,
+// or a Telegram chat. A destination with prefectures only receives messages
+// affecting them, like a WEBHOOK_ROUTES entry.
+type Destination struct {
+	Type        string   `json:"type"`
+	URL         string   `json:"url,omitempty"`
+	BotToken    string   `json:"botToken,omitempty"`
+	ChatID      string   `json:"chatId,omitempty"`
+	Prefectures []string `json:"prefectures,omitempty"`
+}
+
+// Parse DESTINATIONS, a comma-separated list of "type:target" entries,
+// optionally limited to prefectures as in WEBHOOK_ROUTES:
+//
+//	discord:https://discord.com/api/webhooks/...
+//	Tokyo|Kanagawa=slack:https://hooks.slack.com/services/...
+//	telegram:123456:ABC-DEF/-1001234567   (bot token / chat ID)
+//
+// The type is one of the notifier formats (discord, slack, teams) or telegram.
+func parseDestinations(value string) []Destination {
+	var dests []Destination
+	for _, entry := range parseList(value) {
+		prefs, target := "", entry
+		if !hasDestinationType(entry) {
+			prefs, target, _ = strings.Cut(entry, "=")
+		}
+		kind, target, _ := strings.Cut(strings.TrimSpace(target), ":")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		target = strings.TrimSpace(target)
+		d := Destination{Type: kind}
+		switch {
+		case kind == "telegram":
+			if i := strings.LastIndex(target, "/"); i > 0 {
+				d.BotToken, d.ChatID = target[:i], target[i+1:]
+			}
+		case notifiers[kind] != nil:
+			d.URL = target
+		default:
+			logWarn("config", "Invalid DESTINATIONS entry, unknown type %q (use discord, slack, teams or telegram)", kind)
+			continue
+		}
+		if d.URL == "" && (d.BotToken == "" || d.ChatID == "") {
+			logWarn("config", "Invalid DESTINATIONS entry of type %s, expected %s", kind, destinationUsage(kind))
+			continue
+		}
+		d.Prefectures = parsePrefectureSet("DESTINATIONS", prefs)
+		dests = append(dests, d)
+	}
+	return dests
+}
+
+func hasDestinationType(entry string) bool {
+	kind, _, found := strings.Cut(entry, ":")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	return found && (kind == "telegram" || notifiers[kind] != nil)
+}
+
+func destinationUsage(kind string) string {
+	if kind == "telegram" {
+		return "telegram:BOT_TOKEN/CHAT_ID"
+	}
+	return kind + ":URL"
+}
+
+// Every configured destination: DESTINATIONS, then the older variables, which
+// keep working as before. DISCORD_WEBHOOK_URL and WEBHOOK_ROUTES post in the
+// SINK_TYPE format.
+func configuredDestinations(env Env) []Destination {
+	dests := append([]Destination(nil), env.Destinations...)
+	dests = append(dests, webhookDestinations(env.SinkType, env.DiscordWebhookURL)...)
+	for _, route := range env.WebhookRoutes {
+		dests = append(dests, Destination{Type: env.SinkType, URL: route.URL, Prefectures: route.Prefectures})
+	}
+	if telegramConfigured(env) {
+		dests = append(dests, Destination{Type: "telegram", BotToken: env.TelegramBotToken, ChatID: env.TelegramChatID})
+	}
+	return dests
+}
+
+// Destinations for a comma-separated list of webhook URLs of one format
+func webhookDestinations(kind, urls string) []Destination {
+	var dests []Destination
+	for _, u := range parseList(urls) {
+		if u != "" {
+			dests = append(dests, Destination{Type: kind, URL: u})
+		}
+	}
+	return dests
+}
+
+// The destinations a message goes to, each once. Messages naming no
+// prefecture (status notices, tsunami lifts) go everywhere.
+func destinationsFor(affected []string) []Destination {
+	var dests []Destination
+	seen := make(map[string]bool)
+	for _, d := range configuredDestinations(currentEnv()) {
+		if !prefecturesMatch(d.Prefectures, affected) || seen[d.key()] {
+			continue
+		}
+		seen[d.key()] = true
+		dests = append(dests, d)
+	}
+	return dests
+}
+
+func (d Destination) key() string {
+	if d.Type == "telegram" {
+		return "telegram:" + d.BotToken + "/" + d.ChatID
+	}
+	return d.URL
+}
+
+// Where a destination is, for logs (webhook tokens masked)
+func (d Destination) String() string {
+	if d.Type == "telegram" {
+		return "telegram:" + d.ChatID
+	}
+	return d.Type + ":" + maskWebhookURL(d.URL)
+}
+
+// Post a message to one destination
+func sendToDestination(d Destination, body MessageBody, mention bool) bool {
+	if d.Type == "telegram" {
+		return sendTelegram(d, body, mention)
+	}
+	return sendWebhook(notifierFor(d.Type), body, d.URL, mention)
+}
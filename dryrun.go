@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+//────────────────────────────
+// Dry Run (DRY_RUN)
+//────────────────────────────
+
+// Log the payload a message would be posted with, and where, instead of
+// posting it. Everything before this point (filters, routing, mentions) has run.
+func dryRunDestination(d Destination, body MessageBody, mention bool) {
+	var payload interface{}
+	if d.Type == "telegram" {
+		payload = telegramPayload(d.ChatID, body, mention)
+	} else {
+		n := notifierFor(d.Type)
+		payload = n.Payload(body, messageContent(body, mention, n.IsDiscord()))
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		logError("dry_run", "Error marshalling payload: %v", err)
+		return
+	}
+	withFields(logFields{"event_id": body.EventID, "destination": d.String(), "mention": mention}).
+		info("dry_run", "Dry run, would post to %s:\n%s", d, data)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//────────────────────────────
+// Local Fan-out (FANOUT_PORT)
+//────────────────────────────
+
+// Messages buffered per subscriber; a client that falls further behind is dropped
+const fanoutBuffer = 64
+
+type fanoutClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+var (
+	fanoutMu      sync.Mutex
+	fanoutClients = make(map[*fanoutClient]bool)
+	fanoutUpgrade = websocket.Upgrader{}
+)
+
+// Serve the raw upstream feed to local WebSocket clients on FANOUT_PORT
+func registerFanout() {
+	env := currentEnv()
+	if env.FanoutPort == "" {
+		return
+	}
+	handleHTTP(env.FanoutPort, "/", handleFanout)
+}
+
+func handleFanout(w http.ResponseWriter, r *http.Request) {
+	conn, err := fanoutUpgrade.Upgrade(w, r, nil)
+	if err != nil {
+		logWarn("fanout", "Fan-out upgrade failed: %v", err)
+		return
+	}
+	client := &fanoutClient{conn: conn, send: make(chan []byte, fanoutBuffer)}
+	fanoutMu.Lock()
+	fanoutClients[client] = true
+	fanoutMu.Unlock()
+	if currentEnv().EnableLogger {
+		withFields(logFields{"remote": r.RemoteAddr}).info("fanout", "Fan-out client connected: %s", r.RemoteAddr)
+	}
+
+	go func() {
+		defer conn.Close()
+		for msg := range client.send {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				removeFanoutClient(client)
+				return
+			}
+		}
+	}()
+	// Subscribers only listen; reading detects when they go away
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			removeFanoutClient(client)
+			return
+		}
+	}
+}
+
+func removeFanoutClient(client *fanoutClient) {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	if fanoutClients[client] {
+		delete(fanoutClients, client)
+		close(client.send)
+	}
+}
+
+// Push a raw upstream message to every subscriber without blocking
+func broadcastRaw(message []byte) {
+	fanoutMu.Lock()
+	defer fanoutMu.Unlock()
+	for client := range fanoutClients {
+		select {
+		case client.send <- message:
+		default:
+			logWarn("fanout", "Fan-out client too slow, disconnecting")
+			delete(fanoutClients, client)
+			close(client.send)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//────────────────────────────
+// Earthquake Embed Fields (FIELDS)
+//────────────────────────────
+
+// Builds the embed fields of one kind; an empty result means nothing to show
+type fieldBuilder func(ev NormalizedEvent, groups []PointGroup) []MessageField
+
+var fieldBuilders = map[string]fieldBuilder{
+	"epicenter":        epicenterFields,
+	"magnitude":        magnitudeFields,
+	"depth":            depthFields,
+	"map-link":         mapLinkFields,
+	"tsunami-status":   tsunamiStatusFields,
+	"intensity-groups": intensityGroupFields,
+	"point-count":      pointCountFields,
+	"breakdown":        breakdownFields,
+	"cities":           cityFields,
+}
+
+// Field layout used when FIELDS is unset, following the individual toggles
+func defaultFields(env Env) []string {
+	var fields []string
+	// Hypocenter details first; each is left out when the report lacks it
+	if env.ShowEpicenter {
+		fields = append(fields, "epicenter", "magnitude", "depth", "map-link")
+	}
+	// Only shown when a tsunami is possible
+	fields = append(fields, "tsunami-status", "intensity-groups")
+	if env.DetailedBreakdown {
+		fields = append(fields, "breakdown")
+	}
+	if env.ShowCities {
+		fields = append(fields, "cities")
+	}
+	return fields
+}
+
+// Parse FIELDS, dropping (and reporting) unknown field kinds
+func parseFields(value string) []string {
+	var fields []string
+	for _, f := range parseList(value) {
+		if _, ok := fieldBuilders[f]; !ok {
+			logWarn("config", "Unknown entry in FIELDS, ignoring: %s", f)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// The epicenter is shown separately, since no station there may have reported
+func epicenterFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	epicenter := tr("unknown")
+	if ev.Epicenter != nil && ev.Epicenter.Name != "" {
+		epicenter = ev.Epicenter.Translated
+	}
+	return []MessageField{{Name: tr("epicenter"), Value: epicenter, Inline: false}}
+}
+
+func magnitudeFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	if ev.Magnitude == nil {
+		return nil
+	}
+	return []MessageField{{Name: tr("magnitude"), Value: fmt.Sprintf("M%.1f", *ev.Magnitude), Inline: true}}
+}
+
+// A depth of 0 means a very shallow quake
+func depthFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	if ev.DepthKm == nil {
+		return nil
+	}
+	value := fmt.Sprintf(tr("depth_value"), *ev.DepthKm)
+	if *ev.DepthKm == 0 {
+		value = tr("very_shallow")
+	}
+	return []MessageField{{Name: tr("depth"), Value: value, Inline: true}}
+}
+
+func mapLinkFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	if ev.Epicenter == nil || ev.Epicenter.Latitude == nil || ev.Epicenter.Longitude == nil {
+		return nil
+	}
+	lat, lon := *ev.Epicenter.Latitude, *ev.Epicenter.Longitude
+	link := fmt.Sprintf("https://www.google.com/maps?q=%.2f,%.2f", lat, lon)
+	return []MessageField{{Name: tr("map"), Value: fmt.Sprintf("[%.2f, %.2f](%s)", lat, lon, link), Inline: true}}
+}
+
+// Whether a JMA tsunami code says anything worth showing
+func tsunamiNoteworthy(code string) bool {
+	return code != "" && code != "None" && code != "Unknown"
+}
+
+// Readable text for a JMA tsunami code; codes without a translation are shown as is
+func tsunamiStatusText(code string) string {
+	if s := tr("tsunami_code_" + code); s != "" {
+		return s
+	}
+	return code
+}
+
+// Domestic and foreign tsunami status, leaving out "None" and "Unknown"
+func tsunamiStatusFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	var parts []string
+	if tsunamiNoteworthy(ev.DomesticTsunami) {
+		parts = append(parts, fmt.Sprintf(tr("domestic"), tsunamiStatusText(ev.DomesticTsunami)))
+	}
+	if tsunamiNoteworthy(ev.ForeignTsunami) {
+		parts = append(parts, fmt.Sprintf(tr("foreign"), tsunamiStatusText(ev.ForeignTsunami)))
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return []MessageField{{Name: tr("tsunami"), Value: strings.Join(parts, "\n"), Inline: false}}
+}
+
+// Sort region names in each group alphabetically
+func intensityGroupFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	var fields []MessageField
+	for _, g := range groups {
+		sort.Strings(g.Regions)
+		fields = append(fields, MessageField{
+			Name:   fmt.Sprintf(tr("seismic_intensity"), withRawScale(g.ScaleStr, g.ScaleInt)),
+			Value:  strings.Join(g.Regions, ", "),
+			Inline: true,
+		})
+	}
+	return fields
+}
+
+func pointCountFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	return []MessageField{{Name: tr("observation_points"), Value: fmt.Sprintf("%d", ev.PointCount), Inline: true}}
+}
+
+// The full intensity profile of each affected target prefecture
+func breakdownFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	env := currentEnv()
+	var fields []MessageField
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			if !containsString(env.TargetPrefectures, region) {
+				continue
+			}
+			fields = append(fields, MessageField{
+				Name:   fmt.Sprintf(tr("breakdown_for"), region),
+				Value:  intensityBreakdown(region, g.Points),
+				Inline: false,
+			})
+		}
+	}
+	return fields
+}
+
+// The observed cities nested under each affected (target) prefecture
+func cityFields(ev NormalizedEvent, groups []PointGroup) []MessageField {
+	env := currentEnv()
+	var fields []MessageField
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			if len(env.TargetPrefectures) > 0 && !containsString(env.TargetPrefectures, region) {
+				continue
+			}
+			fields = append(fields, MessageField{
+				Name:   fmt.Sprintf(tr("cities_in"), region),
+				Value:  cityBreakdown(region, g.Points),
+				Inline: false,
+			})
+		}
+	}
+	return fields
+}
+
+//────────────────────────────
+// Discord Embed Limits
+//────────────────────────────
+
+const (
+	maxEmbedFields = 25
+	maxFieldValue  = 1024
+	// Total text of an embed (title, description and fields); the title is
+	// short, so some room is kept for it and the footer
+	maxEmbedText = 6000 - 256
+)
+
+// Field kinds with one field per prefecture, which a nationwide quake can
+// push past the embed limits
+var perPrefectureFields = map[string]bool{"breakdown": true, "cities": true}
+
+// Build the fields within Discord's limits. While they do not fit, the
+// per-prefecture fields give way from the lowest intensity up; the
+// intensity groups still list every prefecture, and a last field counts
+// the ones left out.
+func fitEmbedFields(build func(detailGroups []PointGroup) []MessageField, groups []PointGroup, descriptionLen int) []MessageField {
+	fields := build(groups)
+	detail := groups
+	hidden := 0
+	for len(fields)+min(hidden, 1) > maxEmbedFields || descriptionLen+fieldsLength(fields) > maxEmbedText {
+		var ok bool
+		if detail, ok = dropLowestRegion(detail); !ok {
+			break
+		}
+		hidden++
+		fields = build(detail)
+	}
+	if hidden > 0 {
+		fields = append(fields, MessageField{
+			Name:   tr("more_regions_name"),
+			Value:  fmt.Sprintf(tr("more_regions"), hidden),
+			Inline: false,
+		})
+	}
+	// Fields beyond the limit would have Discord reject the whole alert
+	if len(fields) > maxEmbedFields {
+		fields = fields[:maxEmbedFields]
+	}
+	return fields
+}
+
+// The groups without their lowest-intensity prefecture; ok is false once
+// none is left. The groups passed in are not modified.
+func dropLowestRegion(groups []PointGroup) (rest []PointGroup, ok bool) {
+	for i, g := range groups {
+		if len(g.Regions) == 0 {
+			continue
+		}
+		regions := append([]string(nil), g.Regions...)
+		sort.Strings(regions)
+		rest = append([]PointGroup(nil), groups...)
+		rest[i].Regions = regions[:len(regions)-1]
+		if len(rest[i].Regions) == 0 {
+			rest = append(rest[:i], rest[i+1:]...)
+		}
+		return rest, true
+	}
+	return groups, false
+}
+
+func fieldsLength(fields []MessageField) int {
+	n := 0
+	for _, f := range fields {
+		n += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	return n
+}
+
+// Shorten field values over Discord's 1024 characters at an entry boundary
+// (a line, or an item of a comma-separated list), counting what was cut
+func fitFieldValues(fields []MessageField) []MessageField {
+	for i, f := range fields {
+		if utf8.RuneCountInString(f.Value) > maxFieldValue {
+			fields[i].Value = truncateList(f.Value, maxFieldValue)
+		}
+	}
+	return fields
+}
+
+func truncateList(value string, limit int) string {
+	sep := ", "
+	if strings.Contains(value, "\n") {
+		sep = "\n"
+	}
+	items := strings.Split(value, sep)
+	for kept := len(items) - 1; kept > 0; kept-- {
+		out := strings.Join(items[:kept], sep) + sep + fmt.Sprintf(tr("and_more"), len(items)-kept)
+		if utf8.RuneCountInString(out) <= limit {
+			return out
+		}
+	}
+	// A single entry longer than the limit
+	runes := []rune(value)
+	return string(runes[:limit-1]) + "…"
+}
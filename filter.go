@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//────────────────────────────
+// Earthquake Filter Chain (FILTERS)
+//────────────────────────────
+
+// What the filters get to look at for one earthquake report
+type filterInput struct {
+	Quake  JMAQuake
+	Groups []PointGroup
+	Drill  bool
+	Now    time.Time
+}
+
+// A Filter reports whether an event passes; on a skip, reason says why
+type Filter func(in filterInput) (pass bool, reason string)
+
+type namedFilter struct {
+	Name  string
+	Apply Filter
+}
+
+var filterRegistry = map[string]Filter{
+	"source":       sourceFilter,
+	"drill":        drillFilter,
+	"foreign":      foreignFilter,
+	"points":       pointsFilter,
+	"scale":        scaleFilter,
+	"min-scale":    minScaleFilter,
+	"baseline":     baselineFilter,
+	"target":       targetFilter,
+	"aftershock":   aftershockFilter,
+	"first-report": firstReportFilter,
+}
+
+// Chain used when FILTERS is unset. The stateful filters (aftershock,
+// first-report) record the event as alerted, so they come last.
+//
+// Event ID deduplication is not a filter: onMessage applies it on receipt,
+// before reports are aggregated and for tsunami messages too, and it must not
+// be left out or reordered through FILTERS.
+var defaultFilters = []string{"source", "drill", "points", "scale", "min-scale", "baseline", "target", "aftershock", "first-report"}
+
+// Parse FILTERS, dropping (and reporting) unknown filter names
+func parseFilters(value string) []string {
+	var names []string
+	for _, name := range parseList(value) {
+		if _, ok := filterRegistry[name]; !ok {
+			logWarn("config", "Unknown entry in FILTERS, ignoring: %s", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Resolve filter names into a chain, keeping their order
+func buildFilterChain(names []string) []namedFilter {
+	var chain []namedFilter
+	for _, name := range names {
+		if f, ok := filterRegistry[name]; ok {
+			chain = append(chain, namedFilter{Name: name, Apply: f})
+		}
+	}
+	return chain
+}
+
+// Run the filters in order, stopping at the first one that skips the event.
+// The name and reason of that filter are returned.
+func runFilterChain(chain []namedFilter, in filterInput) (pass bool, name, reason string) {
+	for _, f := range chain {
+		if ok, why := f.Apply(in); !ok {
+			return false, f.Name, why
+		}
+	}
+	return true, "", ""
+}
+
+func sourceFilter(in filterInput) (bool, string) {
+	env := currentEnv()
+	if len(env.AllowedSources) > 0 && !containsString(env.AllowedSources, in.Quake.Issue.Source) {
+		return false, fmt.Sprintf("source %q is not in ALLOWED_SOURCES", in.Quake.Issue.Source)
+	}
+	return true, ""
+}
+
+func drillFilter(in filterInput) (bool, string) {
+	if in.Drill && currentEnv().SkipDrills {
+		return false, "drill distribution"
+	}
+	return true, ""
+}
+
+// Quakes outside Japan (only when listed in FILTERS)
+func foreignFilter(in filterInput) (bool, string) {
+	if in.Quake.Issue.Type == "Foreign" {
+		return false, "foreign earthquake"
+	}
+	return true, ""
+}
+
+// Points with an unknown scale code do not count towards MIN_POINTS
+func pointsFilter(in filterInput) (bool, string) {
+	env := currentEnv()
+	if env.MinPoints <= 0 {
+		return true, ""
+	}
+	valid := 0
+	for _, p := range in.Quake.Points {
+		if _, ok := parseScale(p.Scale); ok {
+			valid++
+		}
+	}
+	if valid < env.MinPoints {
+		return false, fmt.Sprintf("only %d observation points with a known scale (MIN_POINTS %d)", valid, env.MinPoints)
+	}
+	return true, ""
+}
+
+func scaleFilter(in filterInput) (bool, string) {
+	if _, ok := parseScale(in.Quake.Earthquake.MaxScale); !ok {
+		return false, "earthquake scale is undefined"
+	}
+	return true, ""
+}
+
+// MIN_SCALE and MIN_MAGNITUDE are alternatives: a quake passes when it meets
+// either threshold that is set, so that large offshore quakes with weak shaking
+// on land still get through. A missing magnitude meets no magnitude threshold.
+func minScaleFilter(in filterInput) (bool, string) {
+	env := currentEnv()
+	if env.MinScale <= 0 && env.MinMagnitude <= 0 {
+		return true, ""
+	}
+	maxScale := in.Quake.Earthquake.MaxScale
+	magnitude := 0.0
+	if h := in.Quake.Earthquake.Hypocenter; h != nil && h.Magnitude > 0 {
+		magnitude = h.Magnitude
+	}
+	if env.MinScale > 0 && maxScale >= env.MinScale {
+		return true, ""
+	}
+	if env.MinMagnitude > 0 && magnitude >= env.MinMagnitude {
+		return true, ""
+	}
+	var below []string
+	if env.MinScale > 0 {
+		label, _ := parseScale(env.MinScale)
+		below = append(below, fmt.Sprintf("max scale %d is below MIN_SCALE (%s)", maxScale, label))
+	}
+	if env.MinMagnitude > 0 {
+		if magnitude > 0 {
+			below = append(below, fmt.Sprintf("magnitude %.1f is below MIN_MAGNITUDE (%.1f)", magnitude, env.MinMagnitude))
+		} else {
+			below = append(below, fmt.Sprintf("no magnitude to compare with MIN_MAGNITUDE (%.1f)", env.MinMagnitude))
+		}
+	}
+	reason := strings.Join(below, " and ")
+	if len(below) > 1 {
+		reason += " (meeting either would pass)"
+	}
+	return false, reason
+}
+
+func baselineFilter(in filterInput) (bool, string) {
+	if withinBaseline(in.Groups) {
+		return false, "intensity does not exceed BASELINE_INTENSITY in any configured prefecture"
+	}
+	return true, ""
+}
+
+// With TARGET_PREFECTURES set, at least one of them must have observed shaking
+func targetFilter(in filterInput) (bool, string) {
+	if !affectsTargets(groupPrefectures(in.Groups)) {
+		return false, "no target prefectures affected"
+	}
+	return true, ""
+}
+
+// Corrections replace what was posted, so they are never held back as repeats
+func aftershockFilter(in filterInput) (bool, string) {
+	if !isCorrection(in.Quake) && suppressRepeatIntensity(in.Groups, in.Now) {
+		return false, "same or lower intensity already alerted for all affected prefectures"
+	}
+	return true, ""
+}
+
+// Reports of the same quake share its origin time; only the first one goes
+// out, and later ones are suppressed even when they correct or upgrade it
+func firstReportFilter(in filterInput) (bool, string) {
+	if currentEnv().FirstReportOnly && !markSeen("origin:"+in.Quake.Earthquake.Time) {
+		return false, "a report for this quake was already posted (FIRST_REPORT_ONLY)"
+	}
+	return true, ""
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replace the active configuration for the duration of a test
+func withEnv(t *testing.T, env Env) {
+	t.Helper()
+	envMu.Lock()
+	prev := activeEnv
+	activeEnv = env
+	envMu.Unlock()
+	t.Cleanup(func() {
+		envMu.Lock()
+		activeEnv = prev
+		envMu.Unlock()
+	})
+}
+
+// Start from no seen events for the duration of a test
+func withSeenIDs(t *testing.T) {
+	t.Helper()
+	seenMu.Lock()
+	prev := seenIDs
+	seenIDs = make(map[string]time.Time)
+	seenMu.Unlock()
+	t.Cleanup(func() {
+		seenMu.Lock()
+		seenIDs = prev
+		seenMu.Unlock()
+	})
+}
+
+// A filter that records its name when called
+func recordingFilter(name string, pass bool, calls *[]string) namedFilter {
+	return namedFilter{Name: name, Apply: func(in filterInput) (bool, string) {
+		*calls = append(*calls, name)
+		if pass {
+			return true, ""
+		}
+		return false, name + " rejected"
+	}}
+}
+
+func TestRunFilterChainOrder(t *testing.T) {
+	var calls []string
+	chain := []namedFilter{
+		recordingFilter("first", true, &calls),
+		recordingFilter("second", true, &calls),
+		recordingFilter("third", true, &calls),
+	}
+	pass, name, reason := runFilterChain(chain, filterInput{})
+	if !pass || name != "" || reason != "" {
+		t.Errorf("runFilterChain = (%v, %q, %q), want pass", pass, name, reason)
+	}
+	if want := []string{"first", "second", "third"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("filters called %v, want %v", calls, want)
+	}
+}
+
+func TestRunFilterChainShortCircuit(t *testing.T) {
+	var calls []string
+	chain := []namedFilter{
+		recordingFilter("first", true, &calls),
+		recordingFilter("second", false, &calls),
+		recordingFilter("third", true, &calls),
+	}
+	pass, name, reason := runFilterChain(chain, filterInput{})
+	if pass {
+		t.Fatal("runFilterChain passed, want skip")
+	}
+	if name != "second" || reason != "second rejected" {
+		t.Errorf("skipped by (%q, %q), want (\"second\", \"second rejected\")", name, reason)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("filters called %v, want %v", calls, want)
+	}
+}
+
+func TestRunFilterChainEmpty(t *testing.T) {
+	if pass, _, _ := runFilterChain(nil, filterInput{}); !pass {
+		t.Error("empty chain skipped the event")
+	}
+}
+
+func TestBuildFilterChainKeepsOrder(t *testing.T) {
+	chain := buildFilterChain([]string{"scale", "unknown", "source", "points"})
+	var names []string
+	for _, f := range chain {
+		names = append(names, f.Name)
+	}
+	if want := []string{"scale", "source", "points"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("chain %v, want %v", names, want)
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	got := parseFilters("points, bogus ,scale")
+	if want := []string{"points", "scale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFilters = %v, want %v", got, want)
+	}
+	if got := parseFilters(""); got != nil {
+		t.Errorf("parseFilters(\"\") = %v, want nil", got)
+	}
+}
+
+func TestConfiguredOrderDecidesReason(t *testing.T) {
+	withEnv(t, Env{MinPoints: 5, AllowedSources: []string{"気象庁"}})
+	in := filterInput{Quake: JMAQuake{Issue: Issue{Source: "other"}}}
+
+	_, name, _ := runFilterChain(buildFilterChain([]string{"source", "points"}), in)
+	if name != "source" {
+		t.Errorf("source first: skipped by %q, want source", name)
+	}
+	_, name, _ = runFilterChain(buildFilterChain([]string{"points", "source"}), in)
+	if name != "points" {
+		t.Errorf("points first: skipped by %q, want points", name)
+	}
+}
+
+func TestTargetFilter(t *testing.T) {
+	withEnv(t, Env{TargetPrefectures: []string{"Tokyo"}})
+	groups := []PointGroup{{ScaleInt: 30, Regions: []string{"Chiba", "Tokyo"}}}
+	if pass, _ := targetFilter(filterInput{Groups: groups}); !pass {
+		t.Error("targetFilter skipped a quake felt in a target prefecture")
+	}
+	groups = []PointGroup{{ScaleInt: 30, Regions: []string{"Osaka"}}}
+	if pass, _ := targetFilter(filterInput{Groups: groups}); pass {
+		t.Error("targetFilter passed a quake outside the target prefectures")
+	}
+}
+
+func TestMinScaleOrMagnitude(t *testing.T) {
+	quake := func(maxScale int, magnitude float64) filterInput {
+		var eq JMAQuake
+		eq.Earthquake.MaxScale = maxScale
+		eq.Earthquake.Hypocenter = &Hypocenter{Magnitude: magnitude}
+		return filterInput{Quake: eq}
+	}
+	tests := []struct {
+		name string
+		env  Env
+		in   filterInput
+		pass bool
+	}{
+		{"no thresholds", Env{}, quake(10, -1), true},
+		{"intensity met", Env{MinScale: 40, MinMagnitude: 6}, quake(45, 4.2), true},
+		{"magnitude met", Env{MinScale: 40, MinMagnitude: 6}, quake(10, 6.8), true},
+		{"neither met", Env{MinScale: 40, MinMagnitude: 6}, quake(30, 5.0), false},
+		{"unknown magnitude", Env{MinScale: 40, MinMagnitude: 6}, quake(30, -1), false},
+		{"only magnitude set", Env{MinMagnitude: 6}, quake(70, 5.9), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withEnv(t, tt.env)
+			if pass, reason := minScaleFilter(tt.in); pass != tt.pass {
+				t.Errorf("minScaleFilter = (%v, %q), want pass %v", pass, reason, tt.pass)
+			}
+		})
+	}
+}
+
+func TestPointsFilterCountsKnownScales(t *testing.T) {
+	withEnv(t, Env{MinPoints: 2})
+	var eq JMAQuake
+	eq.Points = []Point{{Scale: 10}, {Scale: 46}, {Scale: -1}}
+	if pass, reason := pointsFilter(filterInput{Quake: eq}); pass || !strings.Contains(reason, "only 1 ") {
+		t.Errorf("pointsFilter = (%v, %q), want a skip counting 1 point", pass, reason)
+	}
+	eq.Points = append(eq.Points, Point{Scale: 20})
+	if pass, _ := pointsFilter(filterInput{Quake: eq}); !pass {
+		t.Error("pointsFilter skipped a quake with enough known points")
+	}
+}
+
+func TestFirstReportDropsCorrections(t *testing.T) {
+	withEnv(t, Env{FirstReportOnly: true})
+	withSeenIDs(t)
+	var eq JMAQuake
+	eq.Earthquake.Time = "2024/02/03 04:05:06"
+	eq.Issue.Correct = "None"
+	if pass, _ := firstReportFilter(filterInput{Quake: eq}); !pass {
+		t.Fatal("first report was skipped")
+	}
+	if pass, _ := firstReportFilter(filterInput{Quake: eq}); pass {
+		t.Error("second report passed")
+	}
+	// A correction of an origin time already posted is a follow-up too
+	eq.Issue.Correct = "ScaleOnly"
+	if pass, _ := firstReportFilter(filterInput{Quake: eq}); pass {
+		t.Error("correction of a posted quake passed")
+	}
+	// A correction is still posted when it is the first report of its quake
+	eq.Earthquake.Time = "2024/02/03 04:15:00"
+	if pass, _ := firstReportFilter(filterInput{Quake: eq}); !pass {
+		t.Error("correction of a quake not yet posted was skipped")
+	}
+}
+
+func TestNormalizePrefectures(t *testing.T) {
+	got, unknown := normalizePrefectures("TARGET_PREFECTURES", []string{"tokyo", "Tokyo", "東京都", " 京都 ", "HOKKAIDO", "Tokio"})
+	if want := []string{"Tokyo", "Kyoto", "Hokkaido"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("normalized = %v, want %v", got, want)
+	}
+	if want := []string{"Tokio"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown = %v, want %v", unknown, want)
+	}
+	// With nothing recognized the list still matches nothing, rather than everything
+	got, unknown = normalizePrefectures("TARGET_PREFECTURES", []string{"Tokio"})
+	if len(got) != 1 || len(unknown) != 1 {
+		t.Errorf("all unknown: normalized = %v, unknown = %v", got, unknown)
+	}
+}
+
+// New IDs are written to STATE_FILE in one save after stateSaveDelay
+func TestMarkSeenSavesStateLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	withEnv(t, Env{StateFile: path})
+	withSeenIDs(t)
+	prevDelay := stateSaveDelay
+	stateSaveDelay = 20 * time.Millisecond
+	t.Cleanup(func() { stateSaveDelay = prevDelay })
+
+	for _, id := range []string{"a", "b", "origin:2024/02/03 04:05:06"} {
+		markSeen(id)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("state saved right away")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var state persistedState
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &state) == nil && len(state.SeenIDs) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("state not saved with the three IDs")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
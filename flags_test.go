@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Start from no command-line overrides, and leave none behind
+func resetFlagOverrides(t *testing.T) {
+	t.Helper()
+	flagOverrides = map[string]string{}
+	t.Cleanup(func() { flagOverrides = map[string]string{} })
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"no flags", nil, map[string]string{}, false},
+		{"string flags", []string{"-min-scale", "5 weak", "--targets=Tokyo,Osaka"}, map[string]string{"MIN_SCALE": "5 weak", "TARGET_PREFECTURES": "Tokyo,Osaka"}, false},
+		{"bool flag", []string{"-dry-run"}, map[string]string{"DRY_RUN": "true"}, false},
+		{"bool flag off", []string{"-dry-run=false"}, map[string]string{"DRY_RUN": "false"}, false},
+		{"empty value still overrides", []string{"-webhook", ""}, map[string]string{"DISCORD_WEBHOOK_URL": ""}, false},
+		{"unknown flag", []string{"-verbose"}, map[string]string{}, true},
+		{"positional argument", []string{"-mode", "development", "extra"}, map[string]string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlagOverrides(t)
+			err := parseFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(flagOverrides, tt.want) {
+				t.Errorf("parseFlags(%q) overrides = %v, want %v", tt.args, flagOverrides, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValuePrefersFlags(t *testing.T) {
+	resetFlagOverrides(t)
+	t.Setenv("MIN_SCALE", "30")
+	t.Setenv("LANGUAGE", "ja")
+	t.Setenv("SINK_TYPE", "")
+	if err := parseFlags([]string{"-min-scale", "45"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"MIN_SCALE", "45"},
+		{"LANGUAGE", "ja"},
+		{"SINK_TYPE", ""},
+	}
+	for _, tt := range tests {
+		if got := configValue(tt.key); got != tt.want {
+			t.Errorf("configValue(%s) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+// Every flag stands in for a distinct variable
+func TestConfigFlagsAreDistinct(t *testing.T) {
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for _, f := range configFlags {
+		if names[f.Name] || keys[f.Key] {
+			t.Errorf("flag %s (%s) is defined twice", f.Name, f.Key)
+		}
+		names[f.Name] = true
+		keys[f.Key] = true
+	}
+}
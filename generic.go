@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//────────────────────────────
+// Generic HTTP Sink
+//────────────────────────────
+
+// Sign a payload for the receiver to verify: HMAC-SHA256 over "<timestamp>.<body>".
+// Including the timestamp lets receivers reject replayed requests.
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Header carrying the idempotency key, identical on every delivery of an event
+const idempotencyHeader = "Idempotency-Key"
+
+// Stable per-event key for receivers to deduplicate at-least-once deliveries:
+// the hex SHA-256 of the P2PQuake event ID
+func idempotencyKey(eventID string) string {
+	sum := sha256.Sum256([]byte(eventID))
+	return hex.EncodeToString(sum[:])
+}
+
+// Forward an event to GENERIC_WEBHOOK_URL (no-op when unset)
+func sendGeneric(event NormalizedEvent) bool {
+	env := currentEnv()
+	if env.GenericWebhookURL == "" {
+		return true
+	}
+	// Sandbox data only reaches the receiver with ALLOW_SANDBOX_TO_PROD=true,
+	// as for the other webhooks
+	if event.Sandbox && !env.AllowSandboxToProd && !env.DryRun {
+		logThrottled("warn", "sandbox:generic", "Sandbox event not forwarded to the generic webhook: set ALLOW_SANDBOX_TO_PROD=true")
+		return true
+	}
+	// Drills follow SKIP_DRILLS, and stay off production like the other
+	// webhooks when DRILL_WEBHOOK_URL routes them elsewhere
+	if event.Drill && (env.SkipDrills || env.DrillWebhookURL != "") {
+		logThrottled("info", "drill:generic", "Drill not forwarded to the generic webhook: %s", event.ID)
+		return true
+	}
+	// Maintenance holds back every send; the summary only lists the alerts
+	if env.Maintenance {
+		logThrottled("info", "maintenance:generic", "Maintenance mode, not forwarding to the generic webhook: %s", event.ID)
+		return true
+	}
+	event.IdempotencyKey = idempotencyKey(event.ID)
+	data, err := json.Marshal(event)
+	if err != nil {
+		logError("generic_failed", "Error marshalling generic payload: %v", err)
+		return false
+	}
+	if env.DryRun {
+		withFields(logFields{"event_id": event.ID}).info("dry_run", "Dry run, would forward to the generic webhook:\n%s", data)
+		return true
+	}
+	req, err := http.NewRequest("POST", env.GenericWebhookURL, bytes.NewBuffer(data))
+	if err != nil {
+		logError("generic_failed", "Error creating generic webhook request: %v", err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyHeader, event.IdempotencyKey)
+	if env.GenericWebhookSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Micro-Timestamp", timestamp)
+		req.Header.Set("X-Micro-Signature-256", signPayload(env.GenericWebhookSecret, timestamp, data))
+	}
+	resp, err := doWebhookRequest(req)
+	if err != nil {
+		logError("generic_failed", "Error sending generic webhook request: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		withFields(logFields{"status": resp.StatusCode}).error("generic_failed", "Generic webhook error, status code: %d", resp.StatusCode)
+		return false
+	}
+	return true
+}
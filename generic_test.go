@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignPayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		// Same as: printf '1700000000.{"id":"a"}' | openssl dgst -sha256 -hmac s3cret
+		{"known vector", "s3cret", "1700000000", `{"id":"a"}`, "sha256=5728bde1960c1b8c3818c4173f2e693a41ee30ba692cc5f4d3f887212743c3d8"},
+	}
+	for _, tt := range tests {
+		if got := signPayload(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s: signPayload = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// The timestamp and secret are both part of the signature
+	base := signPayload("s3cret", "1700000000", []byte("{}"))
+	if signPayload("s3cret", "1700000001", []byte("{}")) == base {
+		t.Error("signature does not depend on the timestamp")
+	}
+	if signPayload("other", "1700000000", []byte("{}")) == base {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestSendGenericSignsRequest(t *testing.T) {
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		secret string
+		signed bool
+	}{
+		{"with secret", "s3cret", true},
+		{"without secret", "", false},
+	}
+	for _, tt := range tests {
+		withEnv(t, Env{GenericWebhookURL: srv.URL, GenericWebhookSecret: tt.secret, WebhookTimeout: 5 * time.Second})
+		if !sendGeneric(NormalizedEvent{Type: "earthquake", ID: "abc"}) {
+			t.Fatalf("%s: sendGeneric failed", tt.name)
+		}
+		timestamp := header.Get("X-Micro-Timestamp")
+		signature := header.Get("X-Micro-Signature-256")
+		if !tt.signed {
+			if timestamp != "" || signature != "" {
+				t.Errorf("%s: unsigned request carries %q, %q", tt.name, timestamp, signature)
+			}
+			continue
+		}
+		// What a receiver does: recompute over the timestamp and the raw body
+		if want := signPayload(tt.secret, timestamp, body); timestamp == "" || signature != want {
+			t.Errorf("%s: signature %q, receiver computes %q", tt.name, signature, want)
+		}
+		if got, want := header.Get(idempotencyHeader), idempotencyKey("abc"); got != want {
+			t.Errorf("%s: %s = %q, want %q", tt.name, idempotencyHeader, got, want)
+		}
+	}
+}
+
+// The generic sink gets only the quakes that pass the filters, and drills
+// only when they are neither skipped nor routed to DRILL_WEBHOOK_URL
+func TestGenericSinkFollowsFiltersAndDrills(t *testing.T) {
+	var got []NormalizedEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event NormalizedEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		got = append(got, event)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		env      Env
+		scale    int
+		drill    bool
+		sent     bool
+		drillOut bool
+	}{
+		{"quake", Env{}, 40, false, true, false},
+		{"filtered out", Env{MinScale: 50}, 40, false, false, false},
+		{"drill", Env{}, 40, true, true, true},
+		{"drill with SKIP_DRILLS", Env{SkipDrills: true}, 40, true, false, false},
+		{"drill with DRILL_WEBHOOK_URL", Env{DrillWebhookURL: "https://discord.com/api/webhooks/9/drill", DryRun: true}, 40, true, false, false},
+	}
+	for _, tt := range tests {
+		env := tt.env
+		env.GenericWebhookURL = srv.URL
+		env.WebhookTimeout = 5 * time.Second
+		env.Filters = []string{"scale", "min-scale"}
+		withEnv(t, env)
+		got = nil
+
+		var eq JMAQuake
+		eq.ID = "generic-" + tt.name
+		eq.Earthquake.Time = "2024/01/01 16:10:09"
+		eq.Earthquake.MaxScale = tt.scale
+		eq.Test = tt.drill
+		handleEarthquake(eq, false)
+
+		if sent := len(got) == 1; sent != tt.sent {
+			t.Errorf("%s: forwarded %d events, want sent = %v", tt.name, len(got), tt.sent)
+			continue
+		}
+		if tt.sent && got[0].Drill != tt.drillOut {
+			t.Errorf("%s: drill = %v, want %v", tt.name, got[0].Drill, tt.drillOut)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+//────────────────────────────
+// Health Check (HEALTH_PORT)
+//────────────────────────────
+
+// Last sign of life from upstream in Unix nanoseconds: a message, a pong or a
+// successful poll
+var lastActivity atomic.Int64
+
+func noteActivity() {
+	lastActivity.Store(time.Now().UnixNano())
+}
+
+// Healthy while connected with upstream activity within HEALTH_MAX_SILENCE.
+// The pings sent every PING_INTERVAL keep a quiet but live connection fresh.
+func healthStatus(now time.Time, maxSilence time.Duration) (bool, string) {
+	if !metricConnected.Load() {
+		return false, "not connected"
+	}
+	last := lastActivity.Load()
+	if last == 0 {
+		return false, "nothing received yet"
+	}
+	if silence := now.Sub(time.Unix(0, last)); maxSilence > 0 && silence > maxSilence {
+		return false, fmt.Sprintf("nothing received for %v", silence.Round(time.Second))
+	}
+	return true, "ok"
+}
+
+// Serve /healthz on HEALTH_PORT: 200 when healthy, 503 otherwise
+func registerHealth() {
+	env := currentEnv()
+	if env.HealthPort == "" {
+		return
+	}
+	handleHTTP(env.HealthPort, "/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ok, reason := healthStatus(time.Now(), currentEnv().HealthMaxSilence)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	})
+}
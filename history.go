@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Recent Event History (aftershock suppression, swarm frequency)
+//────────────────────────────
+
+type recentIntensity struct {
+	Scale int
+	Time  time.Time
+}
+
+var (
+	recentMu     sync.Mutex
+	recentByPref = make(map[string]recentIntensity)
+)
+
+// Report whether every affected prefecture was already alerted at the same or a
+// higher intensity within AFTERSHOCK_WINDOW. Escalations are recorded and let through.
+func suppressRepeatIntensity(groups []PointGroup, now time.Time) bool {
+	env := currentEnv()
+	if env.AftershockWindow <= 0 || len(groups) == 0 {
+		return false
+	}
+	recentMu.Lock()
+	defer recentMu.Unlock()
+
+	suppress := true
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			r, ok := recentByPref[region]
+			if !ok || now.Sub(r.Time) > env.AftershockWindow || g.ScaleInt > r.Scale {
+				suppress = false
+			}
+		}
+	}
+	if suppress {
+		return true
+	}
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			r, ok := recentByPref[region]
+			if !ok || now.Sub(r.Time) > env.AftershockWindow || g.ScaleInt >= r.Scale {
+				recentByPref[region] = recentIntensity{Scale: g.ScaleInt, Time: now}
+			}
+		}
+	}
+	return false
+}
+
+// Window of the swarm note. The rolling history keeps quakes for this long, or
+// for TSUNAMI_CORRELATION_WINDOW when that is longer.
+const swarmWindow = time.Hour
+
+type quakeRecord struct {
+	OriginTime string
+	Received   time.Time
+	Name       string
+	Latitude   float64
+	Longitude  float64
+	HasCoords  bool
+	Magnitude  float64
+}
+
+var quakeHistory []quakeRecord
+
+// Great-circle distance between two coordinates in kilometers
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Whether the hypocenter carries real coordinates (-200 means unknown)
+func hasCoordinates(h *Hypocenter) bool {
+	return h != nil && h.Latitude >= -90 && h.Latitude <= 90 && h.Longitude >= -180 && h.Longitude <= 180 &&
+		!(h.Latitude == 0 && h.Longitude == 0)
+}
+
+// Record a quake in the rolling history and return how many quakes (including
+// this one) occurred near its epicenter within the last hour. Reports sharing an
+// origin time are the same quake and are counted once. Returns 0 without a hypocenter.
+func recordQuake(eq JMAQuake, now time.Time) int {
+	env := currentEnv()
+	h := eq.Earthquake.Hypocenter
+	if h == nil || (h.Name == "" && !hasCoordinates(h)) {
+		return 0
+	}
+	current := quakeRecord{
+		OriginTime: eq.Earthquake.Time,
+		Received:   now,
+		Name:       h.Name,
+		Latitude:   h.Latitude,
+		Longitude:  h.Longitude,
+		HasCoords:  hasCoordinates(h),
+		Magnitude:  h.Magnitude,
+	}
+
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	keep := max(swarmWindow, env.TsunamiCorrelation)
+	kept := quakeHistory[:0]
+	for _, r := range quakeHistory {
+		if now.Sub(r.Received) <= keep && r.OriginTime != current.OriginTime {
+			kept = append(kept, r)
+		}
+	}
+	quakeHistory = append(kept, current)
+
+	count := 0
+	for _, r := range quakeHistory {
+		if now.Sub(r.Received) > swarmWindow {
+			continue
+		}
+		if r.HasCoords && current.HasCoords {
+			if haversineKm(r.Latitude, r.Longitude, current.Latitude, current.Longitude) <= env.SwarmRadiusKm {
+				count++
+			}
+		} else if r.Name != "" && r.Name == current.Name {
+			count++
+		}
+	}
+	return count
+}
+
+// Find the quake a tsunami report most likely belongs to: the largest one
+// received within the correlation window
+func relatedQuake(now time.Time, window time.Duration) (quakeRecord, bool) {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	var best quakeRecord
+	found := false
+	for _, r := range quakeHistory {
+		if now.Sub(r.Received) > window {
+			continue
+		}
+		if !found || r.Magnitude > best.Magnitude {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+//────────────────────────────
+// Shared HTTP Clients (WEBHOOK_TIMEOUT)
+//────────────────────────────
+
+// Transport shared by every outbound HTTP client, so that connections (and
+// their TLS sessions) are reused and all traffic goes through the proxy.
+// It is safe for concurrent use by the message workers.
+var httpTransport = newHTTPTransport()
+
+// Client for webhook and Telegram requests. It has no overall timeout;
+// WEBHOOK_TIMEOUT is applied per request by doWebhookRequest, so that a
+// reload can change it.
+var webhookClient = &http.Client{Transport: httpTransport}
+
+// Client for upstream APIs (P2PQuake history, JMA)
+var apiClient = &http.Client{Timeout: 10 * time.Second, Transport: httpTransport}
+
+func newHTTPTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxyForRequest
+	// Bursts post to a handful of hosts from several workers at once
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = 16
+	t.IdleConnTimeout = 90 * time.Second
+	return t
+}
+
+// Send a webhook request within WEBHOOK_TIMEOUT. The deadline also covers
+// reading the response body, and is released when the body is closed.
+func doWebhookRequest(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), currentEnv().WebhookTimeout)
+	resp, err := webhookClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,204 @@
+package main
+
+import "strconv"
+
+//────────────────────────────
+// Localization (LANGUAGE)
+//────────────────────────────
+
+// User-facing strings by language; format verbs are filled in by the callers
+var localizedStrings = map[string]map[string]string{
+	"en": {
+		"test_distribution":    "This information is a test distribution\n",
+		"drill":                "This is a drill\n",
+		"test_alert":           "This is a test alert requested by an operator\n",
+		"earthquake_title":     "Earthquake Information",
+		"max_intensity":        "Maximum intensity %s was received at %s on %s.",
+		"area_only":            "\nEstimated regional intensity: based on area-level data, coarser than station measurements.",
+		"swarm_note":           "\nThis is the %s quake in this region within the last hour.",
+		"epicenter":            "Epicenter",
+		"unknown":              "Unknown",
+		"magnitude":            "Magnitude",
+		"depth":                "Depth",
+		"depth_value":          "%.0f km",
+		"very_shallow":         "Very shallow",
+		"map":                  "Map",
+		"tsunami":              "Tsunami",
+		"domestic":             "Domestic: %s",
+		"foreign":              "Foreign: %s",
+		"seismic_intensity":    "Seismic Intensity %s",
+		"observation_points":   "Observation Points",
+		"breakdown_for":        "Breakdown for %s",
+		"cities_in":            "Cities in %s",
+		"tsunami_title":        "Tsunami Information",
+		"tsunami_issued":       "Tsunami information has been issued.",
+		"tsunami_related":      "Tsunami information issued for %s.",
+		"tsunami_epicenter":    " (Epicenter: %s)",
+		"related_quake":        "the quake",
+		"related_quake_mag":    "the M%.1f quake",
+		"related_quake_time":   " reported at %02d:%02d",
+		"grade_major_warning":  "Major Tsunami Warning",
+		"grade_warning":        "Tsunami Warning",
+		"grade_watch":          "Tsunami Advisory",
+		"grade_unknown":        "Unknown",
+		"tsunami_highest":      "**Highest level in effect: %s**",
+		"tsunami_area_count":   "%s: %d areas",
+		"tsunami_area_one":     "%s: 1 area",
+		"tsunami_immediate":    " (immediate)",
+		"tsunami_arrival":      "arrival %s",
+		"tsunami_max_height":   "max %s",
+		"tsunami_lifted_title": "Tsunami Warning Lifted",
+		"tsunami_lifted":       "The tsunami warnings and advisories have been lifted.",
+		"more_areas":           "…and %d more areas under %s",
+		"regions":              "Regions",
+		"title_major":          "Major Earthquake",
+		"title_strong":         "Strong Earthquake",
+		"title_moderate":       "Moderate Earthquake",
+		"title_minor":          "Minor Earthquake",
+		"poi_distance":         "Epicenter %.0f km from %s",
+		"upgraded":             "**Intensity upgraded from %s to %s**\n",
+		"sandbox_title":        "[TEST] %s",
+		"redelivered":          "Delivery delayed by an outage (first attempted %s)",
+		"maintenance_title":    "Maintenance Ended",
+		"maintenance_summary":  "%d alerts were not posted during maintenance.",
+		"maintenance_more":     "and %d more",
+		"regions_pending":      "Detailed region data pending",
+		"intensity_field_head": "Seismic Intensity",
+		"dead_webhook_title":   "Webhook Unavailable",
+		"dead_webhook":         "The webhook %s keeps answering HTTP %d and is no longer used. It was probably deleted or its token revoked.",
+		"more_regions_name":    "Other Prefectures",
+		"more_regions":         "Details for %d more prefectures with lower intensity are not shown",
+		"and_more":             "…and %d more",
+		"revised_title":        "%s (Revised)",
+		"correction_note":      "**Correction (%s)** of the earlier report for the quake at %s, which it replaces\n",
+
+		// JMA tsunami codes of earthquake reports
+		"tsunami_code_Checking":           "Under investigation",
+		"tsunami_code_NonEffective":       "Slight sea level changes, no damage expected",
+		"tsunami_code_Watch":              "Tsunami advisory",
+		"tsunami_code_Warning":            "Tsunami forecast issued",
+		"tsunami_code_NonEffectiveNearby": "Slight sea level changes possible near the epicenter",
+		"tsunami_code_WarningNearby":      "Tsunami possible near the epicenter",
+		"tsunami_code_WarningPacific":     "Tsunami possible in the Pacific",
+		"tsunami_code_WarningPacificWide": "Tsunami possible across the Pacific",
+		"tsunami_code_WarningIndian":      "Tsunami possible in the Indian Ocean",
+		"tsunami_code_WarningIndianWide":  "Tsunami possible across the Indian Ocean",
+		"tsunami_code_Potential":          "Tsunami generally possible for a quake of this size",
+
+		// JMA correction codes of earthquake reports
+		"correct_ScaleOnly":           "intensity corrected",
+		"correct_DestinationOnly":     "hypocenter corrected",
+		"correct_ScaleAndDestination": "intensity and hypocenter corrected",
+	},
+	"ja": {
+		"test_distribution":    "この情報はテスト配信です\n",
+		"drill":                "これは訓練です\n",
+		"test_alert":           "これは運用者によるテスト通知です\n",
+		"earthquake_title":     "地震情報",
+		"max_intensity":        "最大震度%[1]sを観測しました（%[3]s %[2]s）。",
+		"area_only":            "\n推定震度：地域単位のデータに基づくため、観測点の計測より精度が低い値です。",
+		"swarm_note":           "\nこの地域で過去1時間に発生した%s地震です。",
+		"epicenter":            "震源",
+		"unknown":              "不明",
+		"magnitude":            "マグニチュード",
+		"depth":                "深さ",
+		"depth_value":          "%.0fkm",
+		"very_shallow":         "ごく浅い",
+		"map":                  "地図",
+		"tsunami":              "津波",
+		"domestic":             "国内: %s",
+		"foreign":              "海外: %s",
+		"seismic_intensity":    "震度%s",
+		"observation_points":   "観測点数",
+		"breakdown_for":        "%sの震度内訳",
+		"cities_in":            "%sの市区町村",
+		"tsunami_title":        "津波情報",
+		"tsunami_issued":       "津波情報が発表されました。",
+		"tsunami_related":      "%sに関する津波情報が発表されました。",
+		"tsunami_epicenter":    "（震源: %s）",
+		"related_quake":        "地震",
+		"related_quake_mag":    "M%.1fの地震",
+		"related_quake_time":   "（%02d:%02d発生）",
+		"grade_major_warning":  "大津波警報",
+		"grade_warning":        "津波警報",
+		"grade_watch":          "津波注意報",
+		"grade_unknown":        "不明",
+		"tsunami_highest":      "**発表中の最高レベル: %s**",
+		"tsunami_area_count":   "%s: %d地域",
+		"tsunami_area_one":     "%s: 1地域",
+		"tsunami_immediate":    "（直ちに来襲）",
+		"tsunami_arrival":      "到達予想 %s",
+		"tsunami_max_height":   "予想高さ %s",
+		"tsunami_lifted_title": "津波警報解除",
+		"tsunami_lifted":       "津波警報・注意報は解除されました。",
+		"more_areas":           "…ほか%[2]sの%[1]d地域",
+		"regions":              "地域",
+		"title_major":          "大地震",
+		"title_strong":         "強い地震",
+		"title_moderate":       "やや強い地震",
+		"title_minor":          "小さな地震",
+		"poi_distance":         "震源は%[2]sから%.0[1]f km",
+		"upgraded":             "**最大震度が%sから%sに引き上げられました**\n",
+		"sandbox_title":        "【テスト】%s",
+		"redelivered":          "障害により配信が遅延しました（初回送信 %s）",
+		"maintenance_title":    "メンテナンス終了",
+		"maintenance_summary":  "メンテナンス中に%d件の通知が保留されました。",
+		"maintenance_more":     "ほか%d件",
+		"regions_pending":      "詳細な地域情報は続報をお待ちください",
+		"intensity_field_head": "震度",
+		"dead_webhook_title":   "Webhookが利用できません",
+		"dead_webhook":         "Webhook %s がHTTP %dを返し続けるため使用を停止しました。削除されたかトークンが無効化された可能性があります。",
+		"more_regions_name":    "その他の都道府県",
+		"more_regions":         "震度の小さい他の%d都道府県の詳細は省略しました",
+		"and_more":             "…ほか%d件",
+		"revised_title":        "%s（訂正）",
+		"correction_note":      "**訂正（%s）** %s発生の地震について先に発表した情報を訂正します\n",
+
+		"tsunami_code_Checking":           "調査中",
+		"tsunami_code_NonEffective":       "若干の海面変動（被害の心配なし）",
+		"tsunami_code_Watch":              "津波注意報",
+		"tsunami_code_Warning":            "津波予報（種類不明）",
+		"tsunami_code_NonEffectiveNearby": "震源の近傍で小さな津波の可能性",
+		"tsunami_code_WarningNearby":      "震源の近傍で津波の可能性",
+		"tsunami_code_WarningPacific":     "太平洋で津波の可能性",
+		"tsunami_code_WarningPacificWide": "太平洋の広域で津波の可能性",
+		"tsunami_code_WarningIndian":      "インド洋で津波の可能性",
+		"tsunami_code_WarningIndianWide":  "インド洋の広域で津波の可能性",
+		"tsunami_code_Potential":          "この規模では一般に津波の可能性あり",
+
+		"correct_ScaleOnly":           "震度の訂正",
+		"correct_DestinationOnly":     "震源の訂正",
+		"correct_ScaleAndDestination": "震度と震源の訂正",
+	},
+}
+
+// Scale labels used when LANGUAGE=ja
+var jaScaleMap = map[int]string{
+	10: "1",
+	20: "2",
+	30: "3",
+	40: "4",
+	45: "5弱",
+	50: "5強",
+	55: "6弱",
+	60: "6強",
+	70: "7",
+}
+
+// Look up a user-facing string in the configured language, falling back to English
+func tr(key string) string {
+	env := currentEnv()
+	if s, ok := localizedStrings[env.Language][key]; ok {
+		return s
+	}
+	return localizedStrings["en"][key]
+}
+
+// Ordinal count for the swarm note (e.g. "3rd" / "3回目の")
+func localOrdinal(n int) string {
+	env := currentEnv()
+	if env.Language == "ja" {
+		return strconv.Itoa(n) + "回目の"
+	}
+	return ordinal(n)
+}
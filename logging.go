@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//────────────────────────────
+// Leveled Logging (LOG_FORMAT)
+//────────────────────────────
+
+// Metadata attached to a log entry; only shown in JSON mode
+type logFields map[string]interface{}
+
+// Logger carrying fields for the next entry, see withFields
+type logger struct {
+	fields logFields
+}
+
+// Set from LOG_FORMAT in loadEnv: "json" writes one JSON object per line,
+// anything else the plain text lines of the log package
+var jsonLogs atomic.Bool
+
+var logOutMu sync.Mutex
+
+func setLogFormat(format string) {
+	switch format {
+	case "json":
+		jsonLogs.Store(true)
+	case "", "text":
+		jsonLogs.Store(false)
+	default:
+		jsonLogs.Store(false)
+		logWarn("config", "Unknown LOG_FORMAT %q, using text", format)
+	}
+}
+
+func withFields(fields logFields) logger {
+	return logger{fields: fields}
+}
+
+func (l logger) write(level, event, format string, args ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	if !jsonLogs.Load() {
+		log.Println(msg)
+		return
+	}
+	entry := make(map[string]interface{}, len(l.fields)+4)
+	for k, v := range l.fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["event"] = event
+	entry["msg"] = msg
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println(msg)
+		return
+	}
+	logOutMu.Lock()
+	defer logOutMu.Unlock()
+	os.Stderr.Write(append(data, '\n'))
+}
+
+func (l logger) info(event, format string, args ...interface{}) {
+	l.write("info", event, format, args...)
+}
+
+func (l logger) warn(event, format string, args ...interface{}) {
+	l.write("warn", event, format, args...)
+}
+
+func (l logger) error(event, format string, args ...interface{}) {
+	l.write("error", event, format, args...)
+}
+
+func logInfo(event, format string, args ...interface{}) {
+	logger{}.info(event, format, args...)
+}
+
+func logWarn(event, format string, args ...interface{}) {
+	logger{}.warn(event, format, args...)
+}
+
+func logError(event, format string, args ...interface{}) {
+	logger{}.error(event, format, args...)
+}
+
+// Log at the fatal level and exit
+func logFatal(event, format string, args ...interface{}) {
+	logger{}.write("fatal", event, format, args...)
+	os.Exit(1)
+}
+
+//────────────────────────────
+// Log Throttling
+//────────────────────────────
+
+type throttleEntry struct {
+	last       time.Time
+	suppressed int
+	// Level, event and text of the latest suppressed line, for the summary
+	level, event, msg string
+	// Set while a summary is scheduled
+	flush *time.Timer
+}
+
+var (
+	throttleMu    sync.Mutex
+	throttleState = make(map[string]*throttleEntry)
+)
+
+// Log a repetitive line at most once per LOG_THROTTLE_INTERVAL for its key,
+// at the given level ("info", "warn" or "error"). Lines dropped in between
+// are counted, and once the interval is over a "(suppressed N similar)"
+// summary is logged, so a flood yields one line per interval.
+// The part of key before the first ":" names the event.
+func logThrottled(level, key, format string, args ...interface{}) {
+	env := currentEnv()
+	msg := fmt.Sprintf(format, args...)
+	event, _, _ := strings.Cut(key, ":")
+	if env.LogThrottleInterval <= 0 {
+		logger{}.write(level, event, "%s", msg)
+		return
+	}
+	throttleMu.Lock()
+	entry, ok := throttleState[key]
+	if !ok {
+		entry = &throttleEntry{}
+		throttleState[key] = entry
+	}
+	now := time.Now()
+	if since := now.Sub(entry.last); since < env.LogThrottleInterval {
+		entry.suppressed++
+		entry.level, entry.event, entry.msg = level, event, msg
+		if entry.flush == nil {
+			entry.flush = time.AfterFunc(env.LogThrottleInterval-since, func() { flushThrottled(key) })
+		}
+		throttleMu.Unlock()
+		return
+	}
+	entry.last = now
+	throttleMu.Unlock()
+	logger{}.write(level, event, "%s", msg)
+}
+
+// Log the summary of the lines suppressed for key. It opens a new interval,
+// so lines that keep coming are summarized again at its end.
+func flushThrottled(key string) {
+	throttleMu.Lock()
+	entry := throttleState[key]
+	if entry == nil {
+		throttleMu.Unlock()
+		return
+	}
+	entry.flush = nil
+	suppressed := entry.suppressed
+	level, event, msg := entry.level, entry.event, entry.msg
+	entry.suppressed = 0
+	if suppressed > 0 {
+		entry.last = time.Now()
+	}
+	throttleMu.Unlock()
+	if suppressed > 0 {
+		withFields(logFields{"suppressed": suppressed}).write(level, event, "%s (suppressed %d similar)", msg, suppressed)
+	}
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io/fs"
 	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Zone data for DISPLAY_TIMEZONE on hosts without it
+	_ "time/tzdata"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
@@ -26,30 +36,495 @@ type Env struct {
 	DiscordMentionEnabled bool
 	TargetPrefectures     []string
 	EnableLogger          bool
+	DetailedBreakdown     bool
+	InitialConnectRetries int
+	InitialConnectDelay   time.Duration
+	NoMentionPrefectures  []string
+	ForumThreadName       string
+	MinPoints             int
+	ScaleMapFile          string
+	ShowEpicenter         bool
+	AuditLog              string
+	AftershockWindow      time.Duration
+	MaxConcurrentMessages int
+	TickerMode            bool
+	ShowCities            bool
+	GenericWebhookURL     string
+	GenericWebhookSecret  string
+	SkipDrills            bool
+	DrillWebhookURL       string
+	ControlPort           string
+	ControlToken          string
+	Transport             string
+	PollInterval          time.Duration
+	ColorScheme           string
+	IncludeAdjacent       bool
+	Fields                []string
+	SwarmNote             bool
+	SwarmRadiusKm         float64
+	DisableColor          bool
+	TsunamiCorrelation    time.Duration
+	LogThrottleInterval   time.Duration
+	EmphasizeMaxScale     bool
+	CSVFile               string
+	DebugFooter           bool
+	InstanceName          string
+	MessageLinks          bool
+	AllowedSources        []string
+	Language              string
+	MentionMinMagnitude   float64
+	WaitForDetailSeconds  int
+	SendMaxAge            time.Duration
+	ShowRawScale          bool
+	SinkType              string
+	ReconnectConcurrency  int
+	BaselineIntensity     map[string]int
+	DeadWebhookAlert      bool
+	TsunamiMaxAreas       int
+	FirstReportOnly       bool
+	AlertCue              string
+	AlertCueMinScale      int
+	PendingRegions        bool
+	Filters               []string
+	TitleSeverity         bool
+	TitleSeverityMap      []severityTitle
+	BreakerThreshold      int
+	BreakerCooldown       time.Duration
+	PointsOfInterest      []PointOfInterest
+	POIRadiusKm           float64
+	FanoutPort            string
+	UpgradeRealert        bool
+	DevWebhookURL         string
+	AllowSandboxToProd    bool
+	JMACrossCheck         bool
+	JMACrossCheckInterval time.Duration
+	JMAForwardMissed      bool
+	Maintenance           bool
+	MaintenanceSummary    bool
+	AggregateSettle       time.Duration
+	AggregateMaxWait      time.Duration
+	AttachPointsThreshold int
+	TsunamiCancelNotice   bool
+	MinScale              int
+	MetricsPort           string
+	PingInterval          time.Duration
+	PongTimeout           time.Duration
+	WebhookRoutes         []WebhookRoute
+	TranslationFile       string
+	ShutdownTimeout       time.Duration
+	RateLimitRetries      int
+	LogFormat             string
+	DryRun                bool
+	EmbedColors           map[int]int
+	HealthPort            string
+	HealthMaxSilence      time.Duration
+	StateFile             string
+	DisplayLocation       *time.Location
+	MinMagnitude          float64
+	TelegramBotToken      string
+	TelegramChatID        string
+	DeadLetterFile        string
+	DeadLetterMaxBytes    int
+	WebhookTimeout        time.Duration
+	Backfill              bool
+	BackfillWindow        time.Duration
+	MentionMinScale       int
+	MentionRoleID         string
+	MentionTiers          []mentionTier
+	// TARGET_PREFECTURES entries that name no prefecture
+	UnknownTargets []string
+	Destinations   []Destination
+	// Variables whose secret reference could not be resolved
+	SecretErrors []string
 }
 
-var env Env
+var (
+	envMu     sync.RWMutex
+	activeEnv Env
+)
+
+// Snapshot of the active configuration, which is swapped as a whole on reload
+func currentEnv() Env {
+	envMu.RLock()
+	defer envMu.RUnlock()
+	return activeEnv
+}
 
-func loadEnv() {
-	// Load .env file if exists (otherwise ignore)
-	_ = godotenv.Load()
-	env.RunMode = os.Getenv("RUN_MODE")
-	env.DiscordWebhookURL = os.Getenv("DISCORD_WEBHOOK_URL")
-	env.DiscordMentionEnabled = os.Getenv("DISCORD_MENTION_ENABLED") == "true"
-	target := os.Getenv("TARGET_PREFECTURES")
-	if target != "" {
-		parts := strings.Split(target, ",")
-		for i, s := range parts {
-			parts[i] = strings.TrimSpace(s)
+var (
+	// Variables set by the process environment, which take precedence over .env
+	processEnvKeys map[string]bool
+	// Variables currently applied from .env
+	dotEnvKeys = make(map[string]bool)
+)
+
+// Apply the .env file (if it exists) for variables not set by the process
+// environment. Unlike godotenv.Load, running it again picks up edits to .env.
+func applyDotEnv() {
+	if processEnvKeys == nil {
+		processEnvKeys = make(map[string]bool)
+		for _, kv := range os.Environ() {
+			key, _, _ := strings.Cut(kv, "=")
+			processEnvKeys[key] = true
+		}
+	}
+	values, err := godotenv.Read()
+	if err != nil {
+		// Report a file that exists but could not be parsed
+		if !errors.Is(err, fs.ErrNotExist) {
+			logWarn("config", "Warning: .env file could not be loaded, its settings are ignored: %v", err)
+		}
+		return
+	}
+	for key := range dotEnvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(dotEnvKeys, key)
+		}
+	}
+	for key, value := range values {
+		if !processEnvKeys[key] {
+			os.Setenv(key, value)
+			dotEnvKeys[key] = true
 		}
-		env.TargetPrefectures = parts
 	}
-	enableLogger := os.Getenv("ENABLE_LOGGER")
+}
+
+// Read an integer variable, falling back to def when unset or invalid
+func getEnvInt(key string, def int) int {
+	v := configValue(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		logWarn("config", "Invalid %s value %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Read a decimal variable, falling back to def when unset or invalid
+func getEnvFloat(key string, def float64) float64 {
+	v := configValue(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		logWarn("config", "Invalid %s value %q, using %v", key, v, def)
+		return def
+	}
+	return f
+}
+
+// Read a duration variable (e.g. "2s"), falling back to def when unset or invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := configValue(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d < 0 {
+		logWarn("config", "Invalid %s value %q, using %v", key, v, def)
+		return def
+	}
+	return d
+}
+
+// Read the configuration and make it active. It is called again on SIGHUP,
+// so everything derived from it (scale labels, field layout) is rebuilt here.
+// Command-line flags take precedence over the variables they stand in for.
+// A configuration that fails validateEnv is not installed.
+func loadEnv() error {
+	applyDotEnv()
+	var env Env
+	secret := func(key string) string {
+		value, err := getEnvSecret(key)
+		if err != nil {
+			logError("config", "Error resolving %s: %v", key, err)
+			env.SecretErrors = append(env.SecretErrors, key)
+		}
+		return value
+	}
+	env.LogFormat = configValue("LOG_FORMAT")
+	setLogFormat(env.LogFormat)
+	env.TranslationFile = configValue("TRANSLATION_FILE")
+	names := defaultTranslations()
+	if env.TranslationFile != "" {
+		merged, err := loadTranslations(env.TranslationFile, names)
+		if err != nil {
+			logError("config", "Error loading translations: %v", err)
+		} else {
+			names = merged
+		}
+	}
+	// Installed first, since the lists parsed below translate prefecture names
+	envMu.Lock()
+	prevTranslations := translations
+	translations = names
+	envMu.Unlock()
+	env.RunMode = configValue("RUN_MODE")
+	env.DryRun = configValue("DRY_RUN") == "true"
+	env.DiscordWebhookURL = secret("DISCORD_WEBHOOK_URL")
+	env.WebhookRoutes = parseRoutes(configValue("WEBHOOK_ROUTES"))
+	env.Destinations = parseDestinations(secret("DESTINATIONS"))
+	env.TelegramBotToken = secret("TELEGRAM_BOT_TOKEN")
+	env.TelegramChatID = configValue("TELEGRAM_CHAT_ID")
+	env.DeadLetterFile = configValue("DEAD_LETTER_FILE")
+	env.DeadLetterMaxBytes = getEnvInt("DEAD_LETTER_MAX_BYTES", 1<<20)
+	env.DiscordMentionEnabled = configValue("DISCORD_MENTION_ENABLED") == "true"
+	env.TargetPrefectures, env.UnknownTargets = normalizePrefectures("TARGET_PREFECTURES", parseList(configValue("TARGET_PREFECTURES")))
+	env.IncludeAdjacent = configValue("INCLUDE_ADJACENT") == "true"
+	if env.IncludeAdjacent {
+		env.TargetPrefectures = expandAdjacent(env.TargetPrefectures)
+	}
+	enableLogger := configValue("ENABLE_LOGGER")
 	if enableLogger == "" {
 		env.EnableLogger = true
 	} else {
 		env.EnableLogger = enableLogger == "true"
 	}
+	env.DetailedBreakdown = configValue("DETAILED_BREAKDOWN") == "true"
+	env.InitialConnectRetries = getEnvInt("INITIAL_CONNECT_RETRIES", 5)
+	env.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	env.PingInterval = getEnvDuration("PING_INTERVAL", 30*time.Second)
+	env.PongTimeout = getEnvDuration("PONG_TIMEOUT", 60*time.Second)
+	env.InitialConnectDelay = getEnvDuration("INITIAL_CONNECT_DELAY", 1*time.Second)
+	env.NoMentionPrefectures, _ = normalizePrefectures("NO_MENTION_PREFECTURES", parseList(configValue("NO_MENTION_PREFECTURES")))
+	env.ForumThreadName = strings.TrimSpace(configValue("FORUM_THREAD_NAME"))
+	env.MinPoints = getEnvInt("MIN_POINTS", 0)
+	env.ShowEpicenter = configValue("SHOW_EPICENTER") != "false"
+	env.AuditLog = configValue("AUDIT_LOG")
+	env.StateFile = configValue("STATE_FILE")
+	env.Backfill = configValue("BACKFILL") == "true"
+	env.BackfillWindow = getEnvDuration("BACKFILL_WINDOW", time.Hour)
+	env.DisplayLocation = jst
+	if name := configValue("DISPLAY_TIMEZONE"); name != "" {
+		if loc, err := time.LoadLocation(name); err != nil {
+			logWarn("config", "Invalid DISPLAY_TIMEZONE %q, using JST: %v", name, err)
+		} else {
+			env.DisplayLocation = loc
+		}
+	}
+	env.AftershockWindow = getEnvDuration("AFTERSHOCK_WINDOW", 0)
+	env.RateLimitRetries = getEnvInt("RATE_LIMIT_RETRIES", 3)
+	env.WebhookTimeout = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	if env.WebhookTimeout <= 0 {
+		env.WebhookTimeout = 10 * time.Second
+	}
+	env.MaxConcurrentMessages = getEnvInt("MAX_CONCURRENT_MESSAGES", 4)
+	env.TickerMode = configValue("TICKER_MODE") == "true"
+	env.ShowCities = configValue("SHOW_CITIES") == "true"
+	env.GenericWebhookURL = strings.TrimSpace(secret("GENERIC_WEBHOOK_URL"))
+	env.GenericWebhookSecret = secret("GENERIC_WEBHOOK_SECRET")
+	env.SkipDrills = configValue("SKIP_DRILLS") == "true"
+	env.DrillWebhookURL = secret("DRILL_WEBHOOK_URL")
+	env.DevWebhookURL = secret("DEV_WEBHOOK_URL")
+	env.AllowSandboxToProd = configValue("ALLOW_SANDBOX_TO_PROD") == "true"
+	env.ControlPort = configValue("CONTROL_PORT")
+	env.FanoutPort = configValue("FANOUT_PORT")
+	env.MetricsPort = configValue("METRICS_PORT")
+	env.HealthPort = configValue("HEALTH_PORT")
+	env.HealthMaxSilence = getEnvDuration("HEALTH_MAX_SILENCE", 2*time.Minute)
+	env.ControlToken = secret("CONTROL_TOKEN")
+	env.Transport = configValue("TRANSPORT")
+	env.ColorScheme = configValue("COLOR_SCHEME")
+	env.DisableColor = configValue("DISABLE_COLOR") == "true"
+	env.EmbedColors = parseEmbedColors(configValue("EMBED_COLORS"))
+	env.TsunamiCorrelation = getEnvDuration("TSUNAMI_CORRELATION_WINDOW", 30*time.Minute)
+	env.LogThrottleInterval = getEnvDuration("LOG_THROTTLE_INTERVAL", 10*time.Second)
+	env.EmphasizeMaxScale = configValue("EMPHASIZE_MAX_SCALE") != "false"
+	env.CSVFile = configValue("CSV_FILE")
+	env.DebugFooter = configValue("DEBUG_FOOTER") == "true"
+	env.MessageLinks = configValue("MESSAGE_LINKS") == "true"
+	env.AllowedSources = parseList(configValue("ALLOWED_SOURCES"))
+	env.MentionMinMagnitude = getEnvFloat("MENTION_MIN_MAGNITUDE", 0)
+	env.MinMagnitude = getEnvFloat("MIN_MAGNITUDE", 0)
+	env.WaitForDetailSeconds = getEnvInt("WAIT_FOR_DETAIL_SECONDS", 0)
+	env.TsunamiCancelNotice = configValue("TSUNAMI_CANCEL_NOTICE") == "true"
+	env.AttachPointsThreshold = getEnvInt("ATTACH_POINTS_THRESHOLD", 0)
+	env.AggregateSettle = getEnvDuration("AGGREGATE_SETTLE", 0)
+	env.AggregateMaxWait = getEnvDuration("AGGREGATE_MAX_WAIT", 2*time.Minute)
+	env.SendMaxAge = getEnvDuration("SEND_MAX_AGE", 2*time.Minute)
+	env.ShowRawScale = configValue("SHOW_RAW_SCALE") == "true"
+	sinkType := configValue("SINK_TYPE")
+	if sinkType == "" {
+		sinkType = configValue("WEBHOOK_FORMAT")
+	}
+	env.SinkType = parseSinkType(sinkType)
+	env.Maintenance = configValue("MAINTENANCE") == "true"
+	env.MaintenanceSummary = configValue("MAINTENANCE_SUMMARY") == "true"
+	env.JMACrossCheck = configValue("JMA_CROSSCHECK") == "true"
+	env.JMACrossCheckInterval = getEnvDuration("JMA_CROSSCHECK_INTERVAL", time.Minute)
+	if env.JMACrossCheckInterval <= 0 {
+		env.JMACrossCheckInterval = time.Minute
+	}
+	env.JMAForwardMissed = configValue("JMA_FORWARD_MISSED") == "true"
+	env.UpgradeRealert = configValue("UPGRADE_REALERT") == "true"
+	env.PointsOfInterest = parsePointsOfInterest(configValue("POINTS_OF_INTEREST"))
+	env.POIRadiusKm = getEnvFloat("POI_RADIUS_KM", 100)
+	env.BreakerThreshold = getEnvInt("BREAKER_THRESHOLD", 5)
+	env.BreakerCooldown = getEnvDuration("BREAKER_COOLDOWN", 30*time.Second)
+	env.TitleSeverity = configValue("TITLE_SEVERITY") == "true"
+	env.TitleSeverityMap = parseSeverityTitles(configValue("TITLE_SEVERITY_MAP"))
+	env.PendingRegions = configValue("PENDING_REGIONS") != "false"
+	env.AlertCue = configValue("ALERT_CUE")
+	env.AlertCueMinScale = getEnvInt("ALERT_CUE_MIN_SCALE", 50)
+	env.FirstReportOnly = configValue("FIRST_REPORT_ONLY") == "true"
+	env.TsunamiMaxAreas = getEnvInt("TSUNAMI_MAX_AREAS", 30)
+	env.DeadWebhookAlert = configValue("DEAD_WEBHOOK_ALERT") == "true"
+	env.BaselineIntensity = parseBaselines(configValue("BASELINE_INTENSITY"))
+	env.ReconnectConcurrency = getEnvInt("RECONNECT_CONCURRENCY", 1)
+	if env.ReconnectConcurrency < 1 {
+		env.ReconnectConcurrency = 1
+	}
+	env.InstanceName = configValue("INSTANCE_NAME")
+	if env.InstanceName == "" {
+		env.InstanceName, _ = os.Hostname()
+	}
+	env.PollInterval = getEnvDuration("POLL_INTERVAL", 10*time.Second)
+	if env.PollInterval <= 0 {
+		env.PollInterval = 10 * time.Second
+	}
+	env.SwarmNote = configValue("SWARM_NOTE") == "true"
+	env.SwarmRadiusKm = getEnvFloat("SWARM_RADIUS_KM", 50)
+	env.Filters = parseFilters(configValue("FILTERS"))
+	if len(env.Filters) == 0 {
+		env.Filters = defaultFilters
+	}
+	env.Fields = parseFields(configValue("FIELDS"))
+	if len(env.Fields) == 0 {
+		env.Fields = defaultFields(env)
+	}
+	env.Language = configValue("LANGUAGE")
+	if _, ok := localizedStrings[env.Language]; !ok {
+		if env.Language != "" {
+			logWarn("config", "Unsupported LANGUAGE %q, using English", env.Language)
+		}
+		env.Language = "en"
+	}
+	scales := baseScaleMap(env.Language)
+	env.ScaleMapFile = configValue("SCALE_MAP_FILE")
+	if env.ScaleMapFile != "" {
+		merged, err := loadScaleMap(env.ScaleMapFile, scales)
+		if err != nil {
+			logError("config", "Error loading scale map: %v", err)
+		} else {
+			scales = merged
+		}
+	}
+
+	env.MinScale = parseMinScale("MIN_SCALE", configValue("MIN_SCALE"), scales)
+	env.MentionMinScale = parseMinScale("MENTION_MIN_SCALE", configValue("MENTION_MIN_SCALE"), scales)
+	env.MentionRoleID = strings.TrimSpace(configValue("MENTION_ROLE_ID"))
+	env.MentionTiers = parseMentionTiers(configValue("MENTION_TIERS"), scales)
+
+	if err := validateEnv(env); err != nil {
+		envMu.Lock()
+		translations = prevTranslations
+		envMu.Unlock()
+		return err
+	}
+	envMu.Lock()
+	wasMaintenance := activeEnv.Maintenance
+	activeEnv = env
+	scaleMap = scales
+	envMu.Unlock()
+	maintenanceChanged(wasMaintenance, env.Maintenance)
+	return nil
+}
+
+// Check a configuration before it becomes active: at startup a failure is
+// fatal, on reload the running configuration is kept.
+func validateEnv(env Env) error {
+	// An unresolved secret reads as unset, which could silently stop posting
+	if len(env.SecretErrors) > 0 {
+		return fmt.Errorf("could not resolve %s", strings.Join(env.SecretErrors, ", "))
+	}
+	// Check DISCORD_WEBHOOK_URL against the selected SINK_TYPE
+	notifier := notifierFor(env.SinkType)
+	if env.DiscordWebhookURL == "" && len(env.WebhookRoutes) == 0 && !telegramConfigured(env) && len(env.Destinations) == 0 {
+		return errors.New("DISCORD_WEBHOOK_URL is not set")
+	}
+	if !validURLs(notifier, env.DiscordWebhookURL) {
+		return errors.New("DISCORD_WEBHOOK_URL is not valid")
+	}
+	for _, route := range env.WebhookRoutes {
+		if !notifier.ValidURL(route.URL) {
+			return errors.New("WEBHOOK_ROUTES contains an invalid URL")
+		}
+	}
+	for _, d := range env.Destinations {
+		if n, ok := notifiers[d.Type]; ok && !n.ValidURL(d.URL) {
+			return fmt.Errorf("DESTINATIONS contains an invalid %s URL", d.Type)
+		}
+	}
+	if !validURLs(notifier, env.DrillWebhookURL) {
+		return errors.New("DRILL_WEBHOOK_URL is not valid")
+	}
+	if !validURLs(notifier, env.DevWebhookURL) {
+		return errors.New("DEV_WEBHOOK_URL is not valid")
+	}
+	// A target list that names no prefecture would silently drop every alert
+	if len(env.UnknownTargets) > 0 && len(env.UnknownTargets) == len(env.TargetPrefectures) {
+		return fmt.Errorf("TARGET_PREFECTURES names no known prefecture: %s", strings.Join(env.UnknownTargets, ", "))
+	}
+	if _, ok := colorSchemes[env.ColorScheme]; env.ColorScheme != "" && !ok {
+		return fmt.Errorf("COLOR_SCHEME is not valid: %q (severity, jma or classic)", env.ColorScheme)
+	}
+	if _, err := parseProxyURL(configValue("PROXY_URL")); err != nil {
+		return fmt.Errorf("PROXY_URL is not valid: %v", err)
+	}
+	return nil
+}
+
+// Whether every URL of a comma-separated list suits the notifier (an empty list does)
+func validURLs(notifier Notifier, urls string) bool {
+	if urls == "" {
+		return true
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if !notifier.ValidURL(strings.TrimSpace(u)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split a comma-separated variable into trimmed entries (nil when empty)
+func parseList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	for i, s := range parts {
+		parts[i] = strings.TrimSpace(s)
+	}
+	return parts
+}
+
+// Parse "Tokyo:30,Osaka:40" into prefecture → scale code (nil when empty).
+// Prefectures are normalized as in TARGET_PREFECTURES; unknown ones are dropped.
+func parseBaselines(value string) map[string]int {
+	var baselines map[string]int
+	for _, entry := range parseList(value) {
+		pref, scale, found := strings.Cut(entry, ":")
+		code, err := strconv.Atoi(strings.TrimSpace(scale))
+		if !found || err != nil {
+			logWarn("config", "Invalid BASELINE_INTENSITY entry %q, expected Prefecture:scale", entry)
+			continue
+		}
+		canonical, ok := canonicalPrefecture(pref)
+		if !ok {
+			logWarn("config", "Unknown prefecture %q in BASELINE_INTENSITY, ignoring it", strings.TrimSpace(pref))
+			continue
+		}
+		if baselines == nil {
+			baselines = make(map[string]int)
+		}
+		baselines[canonical] = code
+	}
+	return baselines
 }
 
 //────────────────────────────
@@ -60,6 +535,16 @@ type BasicData struct {
 	ID   string `json:"id"`
 	Code int    `json:"code"`
 	Time string `json:"time"`
+	// Older name of the ID, which the feed still sends alongside it
+	LegacyID string `json:"_id,omitempty"`
+}
+
+// Take the ID from "_id" when a message lacks "id", so that such messages are
+// still validated and deduplicated by ID
+func (b *BasicData) fillID() {
+	if b.ID == "" {
+		b.ID = b.LegacyID
+	}
 }
 
 type Issue struct {
@@ -92,30 +577,39 @@ type Point struct {
 	Scale  int    `json:"scale"`
 }
 
+type Comments struct {
+	FreeFormComment string `json:"freeFormComment,omitempty"`
+}
+
 type JMAQuake struct {
 	BasicData
 	Issue      Issue      `json:"issue"`
 	Earthquake Earthquake `json:"earthquake"`
 	Points     []Point    `json:"points"`
+	Comments   Comments   `json:"comments"`
+	// Set on training distributions
+	Test bool `json:"test,omitempty"`
+}
+
+type TsunamiArea struct {
+	Grade       string `json:"grade,omitempty"`
+	Immediate   bool   `json:"immediate,omitempty"`
+	Name        string `json:"name,omitempty"`
+	FirstHeight *struct {
+		ArrivalTime string `json:"arrivalTime,omitempty"`
+		Condition   string `json:"condition,omitempty"`
+	} `json:"firstHeight,omitempty"`
+	MaxHeight *struct {
+		Description string  `json:"description,omitempty"`
+		Value       float64 `json:"value,omitempty"`
+	} `json:"maxHeight,omitempty"`
 }
 
 type JMATsunami struct {
 	BasicData
-	Cancelled bool  `json:"cancelled"`
-	Issue     Issue `json:"issue"`
-	Areas     []struct {
-		Grade       string `json:"grade,omitempty"`
-		Immediate   bool   `json:"immediate,omitempty"`
-		Name        string `json:"name,omitempty"`
-		FirstHeight *struct {
-			ArrivalTime string `json:"arrivalTime,omitempty"`
-			Condition   string `json:"condition,omitempty"`
-		} `json:"firstHeight,omitempty"`
-		MaxHeight *struct {
-			Description string  `json:"description,omitempty"`
-			Value       float64 `json:"value,omitempty"`
-		} `json:"maxHeight,omitempty"`
-	} `json:"areas"`
+	Cancelled bool          `json:"cancelled"`
+	Issue     Issue         `json:"issue"`
+	Areas     []TsunamiArea `json:"areas"`
 }
 
 // Discord message struct
@@ -125,16 +619,50 @@ type MessageField struct {
 	Inline bool   `json:"inline"`
 }
 
+type MessageFooter struct {
+	Text string `json:"text"`
+}
+
 type MessageBody struct {
 	Title       string         `json:"title"`
 	Description string         `json:"description"`
 	Fields      []MessageField `json:"fields"`
-	Color       int            `json:"color"`
+	Color       int            `json:"color,omitempty"`
+	Footer      *MessageFooter `json:"footer,omitempty"`
+	// Post name used when the webhook targets a forum channel
+	ThreadName string `json:"-"`
+	// Source event ID, recorded in the audit log
+	EventID string `json:"-"`
+	// Training distribution, routed to DrillWebhookURL when set
+	Drill bool `json:"-"`
+	// Received from the sandbox feed, routed to DevWebhookURL
+	Sandbox bool `json:"-"`
+	// Magnitude of the quake (0 when unknown), used for mention thresholds
+	Magnitude float64 `json:"-"`
+	// JMA maximum scale code (0 for non-earthquake messages)
+	MaxScale int `json:"-"`
+	// Tsunami warning, mentioned in the strongest tier
+	Tsunami bool `json:"-"`
+	// How the event got past deduplication, for DEBUG_FOOTER (empty for
+	// messages not received from the feed)
+	Dedup string `json:"-"`
+	// Origin time shared by all reports of a quake, and whether this report
+	// raised its intensity (UPGRADE_REALERT)
+	QuakeKey string `json:"-"`
+	Upgrade  bool   `json:"-"`
+	// Position in the send order
+	Tag SendTag `json:"-"`
+	// File uploaded with the message (Discord only)
+	Attachment *Attachment `json:"-"`
+	// Affected prefectures (translated), used for routing and mentions;
+	// empty for messages not tied to a place
+	Prefectures []string `json:"-"`
 }
 
 type WebhookPayload struct {
-	Content string        `json:"content,omitempty"`
-	Embeds  []MessageBody `json:"embeds"`
+	Content    string        `json:"content,omitempty"`
+	Embeds     []MessageBody `json:"embeds"`
+	ThreadName string        `json:"thread_name,omitempty"`
 }
 
 // Result of grouping (highest intensity in each prefecture)
@@ -142,13 +670,15 @@ type PointGroup struct {
 	ScaleInt int
 	ScaleStr string
 	Regions  []string
+	// Every observation in the group's prefectures, not only the highest one
+	Points []Point
 }
 
 //────────────────────────────
 // Parser & Conversion Functions
 //────────────────────────────
 
-var scaleMap = map[int]string{
+var defaultScaleMap = map[int]string{
 	10: "1",
 	20: "2",
 	30: "3",
@@ -160,7 +690,81 @@ var scaleMap = map[int]string{
 	70: "7",
 }
 
+// Active scale labels (defaults merged with SCALE_MAP_FILE overrides), guarded by envMu
+var scaleMap = defaultScaleMap
+
+// Built-in scale labels for a language
+func baseScaleMap(language string) map[int]string {
+	if language == "ja" {
+		return jaScaleMap
+	}
+	return defaultScaleMap
+}
+
+// Parse an intensity setting such as MIN_SCALE as a scale code ("45") or a
+// label of the active scale map ("4", "5 weak") or JMA's notation ("5-").
+// Empty or invalid means no threshold; key names the setting in the warning.
+func parseMinScale(key, value string, scales map[int]string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if code, err := strconv.Atoi(value); err == nil {
+		if _, ok := scales[code]; ok {
+			return code
+		}
+	}
+	for code, label := range scales {
+		if strings.EqualFold(label, value) {
+			return code
+		}
+	}
+	if code := jmaScaleCode(value); code != 0 {
+		return code
+	}
+	logWarn("config", "Invalid %s value %q, ignoring it", key, value)
+	return 0
+}
+
+// Load scale label overrides from a JSON file such as {"45": "5-", "50": "5+"}.
+// Keys must be scale codes, since parsePoints orders groups by the code itself.
+func loadScaleMap(path string, base map[int]string) (map[int]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	merged := make(map[int]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		code, err := strconv.Atoi(strings.TrimSpace(k))
+		if err != nil || code <= 0 || code > 100 {
+			return nil, fmt.Errorf("invalid scale code %q in %s", k, path)
+		}
+		if strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("empty label for scale code %d in %s", code, path)
+		}
+		merged[code] = v
+	}
+	// Two codes sharing a label would render as separate groups with the same name
+	seen := make(map[string]int)
+	for code, label := range merged {
+		if other, ok := seen[label]; ok {
+			return nil, fmt.Errorf("scale codes %d and %d share the label %q", other, code, label)
+		}
+		seen[label] = code
+	}
+	return merged, nil
+}
+
 func parseScale(scale int) (string, bool) {
+	envMu.RLock()
+	defer envMu.RUnlock()
 	s, ok := scaleMap[scale]
 	return s, ok
 }
@@ -216,33 +820,146 @@ var translateMap = map[string]string{
 	"沖縄県":  "Okinawa",
 }
 
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Common epicenter regions (JMA hypocenter names)
+var regionTranslateMap = map[string]string{
+	"石川県能登地方":  "Noto region, Ishikawa",
+	"千葉県北西部":   "Northwestern Chiba",
+	"千葉県東方沖":   "Off the east coast of Chiba",
+	"千葉県南部":    "Southern Chiba",
+	"茨城県北部":    "Northern Ibaraki",
+	"茨城県南部":    "Southern Ibaraki",
+	"茨城県沖":     "Off the coast of Ibaraki",
+	"福島県沖":     "Off the coast of Fukushima",
+	"福島県中通り":   "Nakadori, Fukushima",
+	"福島県浜通り":   "Hamadori, Fukushima",
+	"宮城県沖":     "Off the coast of Miyagi",
+	"岩手県沖":     "Off the coast of Iwate",
+	"三陸沖":      "Off Sanriku",
+	"青森県東方沖":   "Off the east coast of Aomori",
+	"十勝地方南部":   "Southern Tokachi",
+	"釧路沖":      "Off Kushiro",
+	"根室半島南東沖":  "Southeast off the Nemuro Peninsula",
+	"胆振地方中東部":  "Central and eastern Iburi",
+	"埼玉県南部":    "Southern Saitama",
+	"東京都23区":   "Tokyo 23 wards",
+	"神奈川県東部":   "Eastern Kanagawa",
+	"神奈川県西部":   "Western Kanagawa",
+	"伊豆大島近海":   "Near Izu Oshima",
+	"長野県北部":    "Northern Nagano",
+	"長野県中部":    "Central Nagano",
+	"岐阜県飛騨地方":  "Hida region, Gifu",
+	"静岡県東部":    "Eastern Shizuoka",
+	"静岡県西部":    "Western Shizuoka",
+	"和歌山県北部":   "Northern Wakayama",
+	"大阪府北部":    "Northern Osaka",
+	"京都府南部":    "Southern Kyoto",
+	"紀伊水道":     "Kii Channel",
+	"豊後水道":     "Bungo Channel",
+	"日向灘":      "Hyuganada Sea",
+	"熊本県熊本地方":  "Kumamoto region, Kumamoto",
+	"熊本県阿蘇地方":  "Aso region, Kumamoto",
+	"鹿児島県薩摩地方": "Satsuma region, Kagoshima",
+	"トカラ列島近海":  "Near the Tokara Islands",
+	"奄美大島近海":   "Near Amami Oshima",
+	"沖縄本島近海":   "Near Okinawa Island",
+	"与那国島近海":   "Near Yonaguni Island",
+	"台湾付近":     "Near Taiwan",
+}
+
+// Active name translations (built-in maps merged with TRANSLATION_FILE), guarded by envMu
+var translations = defaultTranslations()
+
+// Prefecture and region names built into the binary
+func defaultTranslations() map[string]string {
+	names := make(map[string]string, len(translateMap)+len(regionTranslateMap))
+	for k, v := range regionTranslateMap {
+		names[k] = v
+	}
+	for k, v := range translateMap {
+		names[k] = v
+	}
+	return names
+}
+
+// Load name translations from a JSON file such as {"仙台市青葉区": "Sendai Aoba"}.
+// Entries override the built-in names; prefectures, regions and cities
+// (Point.Addr, shown with SHOW_CITIES) can all be listed.
+func loadTranslations(path string, base map[string]string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		if strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("empty translation for %q in %s", k, path)
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
 func translate(pref string) string {
-	if t, ok := translateMap[pref]; ok {
+	envMu.RLock()
+	defer envMu.RUnlock()
+	if t, ok := translations[pref]; ok {
 		return t
 	}
 	return pref
 }
 
 func parsePoints(points []Point) []PointGroup {
+	// Area entries summarize the stations of their prefecture, so they only
+	// count where no station observation was reported
+	stations := make(map[string]bool)
+	for _, p := range points {
+		if _, ok := parseScale(p.Scale); ok && !p.IsArea {
+			stations[p.Pref] = true
+		}
+	}
+
 	// Record the highest scale received in each prefecture
 	highest := make(map[string]int)
+	observed := make(map[string][]Point)
 	for _, p := range points {
+		if p.IsArea && stations[p.Pref] {
+			continue
+		}
 		if _, ok := parseScale(p.Scale); ok {
 			if cur, exists := highest[p.Pref]; !exists || p.Scale > cur {
 				highest[p.Pref] = p.Scale
 			}
+			observed[p.Pref] = append(observed[p.Pref], p)
 		}
 	}
 
 	// Grouping translated prefecture names by scale
 	groupsMap := make(map[int][]string)
+	pointsMap := make(map[int][]Point)
 	for pref, scaleVal := range highest {
 		groupsMap[scaleVal] = append(groupsMap[scaleVal], translate(pref))
+		pointsMap[scaleVal] = append(pointsMap[scaleVal], observed[pref]...)
 	}
 	var groups []PointGroup
 	for scaleVal, regions := range groupsMap {
 		scaleStr, _ := parseScale(scaleVal)
-		groups = append(groups, PointGroup{ScaleInt: scaleVal, ScaleStr: scaleStr, Regions: regions})
+		groups = append(groups, PointGroup{ScaleInt: scaleVal, ScaleStr: scaleStr, Regions: regions, Points: pointsMap[scaleVal]})
 	}
 
 	// Sort by intensity (e.g. low intensity -> high intensity)
@@ -252,134 +969,594 @@ func parsePoints(points []Point) []PointGroup {
 	return groups
 }
 
+// Append the raw scale code to a label for debugging (e.g. "5 weak (45)") with SHOW_RAW_SCALE
+func withRawScale(label string, code int) string {
+	if !currentEnv().ShowRawScale {
+		return label
+	}
+	return fmt.Sprintf("%s (%d)", label, code)
+}
+
+// Whether a report carries only area-level entries and no station observations
+func areaOnly(points []Point) bool {
+	if len(points) == 0 {
+		return false
+	}
+	for _, p := range points {
+		if !p.IsArea {
+			return false
+		}
+	}
+	return true
+}
+
+// Summarize every intensity observed in a prefecture (e.g. "5 weak: 2, 4: 7")
+func intensityBreakdown(pref string, points []Point) string {
+	counts := make(map[int]int)
+	for _, p := range points {
+		if translate(p.Pref) == pref {
+			counts[p.Scale]++
+		}
+	}
+	scales := make([]int, 0, len(counts))
+	for s := range counts {
+		scales = append(scales, s)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(scales)))
+	parts := make([]string, 0, len(scales))
+	for _, s := range scales {
+		scaleStr, _ := parseScale(s)
+		parts = append(parts, fmt.Sprintf("%s: %d", scaleStr, counts[s]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check that an earthquake message carries the data needed to build an alert
+func validateQuake(eq JMAQuake) error {
+	if eq.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+	if eq.Earthquake.Time == "" {
+		return fmt.Errorf("missing earthquake time")
+	}
+	if _, err := time.Parse("2006/01/02 15:04:05", eq.Earthquake.Time); err != nil {
+		return fmt.Errorf("unparseable earthquake time %q", eq.Earthquake.Time)
+	}
+	// -1 means the scale is not yet known
+	if _, ok := parseScale(eq.Earthquake.MaxScale); !ok && eq.Earthquake.MaxScale != -1 {
+		return fmt.Errorf("max scale %d out of range", eq.Earthquake.MaxScale)
+	}
+	for i, p := range eq.Points {
+		if p.Pref == "" {
+			return fmt.Errorf("point %d has no prefecture", i)
+		}
+	}
+	return nil
+}
+
+// List the cities of a prefecture with their intensity, strongest first (e.g. "Sendai (5 weak), Ishinomaki (4)")
+func cityBreakdown(pref string, points []Point) string {
+	var cities []Point
+	for _, p := range points {
+		if translate(p.Pref) == pref {
+			cities = append(cities, p)
+		}
+	}
+	sort.SliceStable(cities, func(i, j int) bool {
+		if cities[i].Scale != cities[j].Scale {
+			return cities[i].Scale > cities[j].Scale
+		}
+		return cities[i].Addr < cities[j].Addr
+	})
+	parts := make([]string, 0, len(cities))
+	for _, p := range cities {
+		scaleStr, _ := parseScale(p.Scale)
+		parts = append(parts, fmt.Sprintf("%s (%s)", translate(p.Addr), scaleStr))
+	}
+	return strings.Join(parts, ", ")
+}
+
 //────────────────────────────
 // Discord Message Creation & Sending Functions
 //────────────────────────────
 
-func createEarthquakeMessage(timeStr, scale string, groups []PointGroup, isDev bool) MessageBody {
-	t, err := time.Parse("2006/01/02 15:04:05", timeStr)
+func createEarthquakeMessage(eq JMAQuake, scale string, groups []PointGroup, isDev bool) MessageBody {
+	env := currentEnv()
+	t, err := displayTime(eq.Earthquake.Time)
 	if err != nil {
-		t = time.Now()
+		t = time.Now().In(displayLocation())
+	}
+	formattedTime := t.Format("15:04:05")
+	// Outside JST, name the zone so that the time is not mistaken for Japan's
+	if displayLocation() != jst {
+		formattedTime += " " + t.Format("MST")
 	}
-	formattedTime := fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
-	formattedDate := fmt.Sprintf("%04d/%02d/%02d", t.Year(), t.Month(), t.Day())
+	formattedDate := t.Format("2006/01/02")
 	prefix := ""
 	if isDev {
-		prefix = "This information is a test distribution\n"
+		prefix = tr("test_distribution")
 	}
-	description := fmt.Sprintf("%sMaximum intensity %s was received at %s on %s.", prefix, scale, formattedTime, formattedDate)
-	var fields []MessageField
-
-	// Sort region names in each group alphabetically
-	for _, g := range groups {
-		sort.Strings(g.Regions)
-		fields = append(fields, MessageField{
-			Name:   fmt.Sprintf("Seismic Intensity %s", g.ScaleStr),
-			Value:  strings.Join(g.Regions, ", "),
-			Inline: true,
-		})
+	scale = withRawScale(scale, eq.Earthquake.MaxScale)
+	// The max intensity is the most important number, so make it stand out
+	if env.EmphasizeMaxScale {
+		scale = "**" + scale + "**"
+	}
+	description := prefix + fmt.Sprintf(tr("max_intensity"), scale, formattedTime, formattedDate)
+	if areaOnly(eq.Points) {
+		description += tr("area_only")
+	}
+	ev := normalizeQuake(eq, groups)
+	for _, note := range pointOfInterestNotes(ev) {
+		description += "\n" + note
+	}
+	// Early reports can carry a max scale but no points yet: keep the epicenter
+	// and say that the regions will follow, rather than posting a bare embed
+	pending := len(groups) == 0 && env.PendingRegions
+	build := func(detailGroups []PointGroup) []MessageField {
+		var fields []MessageField
+		if pending && !containsString(env.Fields, "epicenter") {
+			fields = append(fields, epicenterFields(ev, groups)...)
+		}
+		for _, kind := range env.Fields {
+			if perPrefectureFields[kind] {
+				fields = append(fields, fieldBuilders[kind](ev, detailGroups)...)
+			} else {
+				fields = append(fields, fieldBuilders[kind](ev, groups)...)
+			}
+		}
+		if pending {
+			fields = append(fields, MessageField{
+				Name:   tr("regions"),
+				Value:  tr("regions_pending"),
+				Inline: false,
+			})
+		}
+		return fitFieldValues(fields)
 	}
+	fields := fitEmbedFields(build, groups, len(description))
 
 	return MessageBody{
-		Title:       "Earthquake Information",
+		Title:       earthquakeTitle(eq.Earthquake.MaxScale),
 		Description: description,
 		Fields:      fields,
-		Color:       2264063,
+		Color:       embedColor(eq.Earthquake.MaxScale),
+		Prefectures: groupPrefectures(groups),
 	}
 }
 
-func sendWebhook(body MessageBody, urlStr string) bool {
-	payload := WebhookPayload{
-		Embeds: []MessageBody{body},
+// Every prefecture with observed shaking, across all intensity groups
+func groupPrefectures(groups []PointGroup) []string {
+	var prefs []string
+	for _, g := range groups {
+		prefs = append(prefs, g.Regions...)
 	}
-	if env.DiscordMentionEnabled {
-		payload.Content = "@everyone"
+	return prefs
+}
+
+// With TARGET_PREFECTURES set, whether any of prefs is a target
+func affectsTargets(prefs []string) bool {
+	env := currentEnv()
+	if len(env.TargetPrefectures) == 0 {
+		return true
 	}
-	data, err := json.Marshal(payload)
-	if err != nil {
-		log.Println("Error marshalling payload:", err)
+	for _, pref := range prefs {
+		if containsString(env.TargetPrefectures, pref) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of the forum post for an event; "auto" derives it from the quake (e.g. "M6.2 Miyagi 2024/06/01")
+func forumThreadName(eq JMAQuake) string {
+	env := currentEnv()
+	if env.ForumThreadName != "auto" {
+		return env.ForumThreadName
+	}
+	var parts []string
+	if h := eq.Earthquake.Hypocenter; h != nil {
+		if h.Magnitude > 0 {
+			parts = append(parts, fmt.Sprintf("M%.1f", h.Magnitude))
+		}
+		if h.Name != "" {
+			parts = append(parts, translate(h.Name))
+		}
+	}
+	if t, err := displayTime(eq.Earthquake.Time); err == nil {
+		parts = append(parts, t.Format("2006/01/02"))
+	}
+	if len(parts) == 0 {
+		return tr("earthquake_title")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Message content: the mention, prefixed with ALERT_CUE for strong shaking so
+// that phone notifications stand out. Role mentions are only used on Discord.
+func messageContent(body MessageBody, mention, discord bool) string {
+	env := currentEnv()
+	var parts []string
+	if env.AlertCue != "" && body.MaxScale >= env.AlertCueMinScale {
+		parts = append(parts, env.AlertCue)
+	}
+	if mention {
+		parts = append(parts, mentionText(body, discord))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Post a message to a webhook in the given notifier's format
+func sendWebhook(notifier Notifier, body MessageBody, urlStr string, mention bool) (ok bool) {
+	env := currentEnv()
+	unlock := lockWebhook(urlStr)
+	defer unlock()
+	if isStale(urlStr, body.Tag, env.SendMaxAge) {
+		withFields(logFields{"event_id": body.EventID}).warn("webhook_stale", "Dropping stale message, a newer one was already posted: %s", body.EventID)
 		return false
 	}
-	req, err := http.NewRequest("POST", urlStr, bytes.NewBuffer(data))
-	if err != nil {
-		log.Println("Error creating request:", err)
+	if isDeadWebhook(urlStr) {
+		logThrottled("warn", "dead:"+urlStr, "Skipping dead webhook %s", maskWebhookURL(urlStr))
 		return false
 	}
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+
+	status := 0
+	retries := 0
+	link := ""
+	failure := ""
+	defer func() {
+		auditSend(body.EventID, urlStr, status, retries, ok, link)
+		recordWebhookResult(urlStr, status, ok, failure)
+		observeWebhook(ok)
+	}()
+
+	data, err := json.Marshal(notifier.Payload(body, messageContent(body, mention, notifier.IsDiscord())))
 	if err != nil {
-		log.Println("Error sending webhook request:", err)
+		failure = err.Error()
+		logError("webhook_failed", "Error marshalling payload: %v", err)
 		return false
 	}
+	method := "POST"
+	target := urlStr
+	// In ticker mode the first post is kept and then edited for every new event.
+	// Upgrades are posted anew, since an edit does not notify anyone.
+	if env.TickerMode && notifier.IsDiscord() && !body.Upgrade {
+		if id := tickerMessageID(urlStr); id != "" {
+			method = "PATCH"
+			target = webhookMessageURL(urlStr, id)
+		}
+	}
+	// wait=true makes Discord return the created message
+	wait := (env.TickerMode || env.MessageLinks) && notifier.IsDiscord()
+	if wait && method == "POST" {
+		target = withQuery(urlStr, "wait", "true")
+	}
+	contentType := "application/json"
+	// Edits keep the original attachments, so files are only uploaded with new posts
+	if body.Attachment != nil && notifier.IsDiscord() && method == "POST" {
+		data, contentType, err = multipartPayload(data, *body.Attachment)
+		if err != nil {
+			failure = err.Error()
+			logError("webhook_failed", "Error building multipart payload: %v", err)
+			return false
+		}
+	}
+	// Asked once per send: a half-open probe keeps its slot through the
+	// rate-limit retries below, and every path out of them records the outcome
+	host := breakerHost(urlStr)
+	if !breakerAllow(host) {
+		failure = "circuit open"
+		logThrottled("warn", "breaker:"+host, "Circuit open, not sending to %s", host)
+		return false
+	}
+	var resp *http.Response
+	// Rate-limited requests are retried after the requested wait, up to RATE_LIMIT_RETRIES times
+	for {
+		req, err := http.NewRequest(method, target, bytes.NewReader(data))
+		if err != nil {
+			failure = err.Error()
+			breakerRecord(host, 0, err)
+			logError("webhook_failed", "Error creating request: %v", err)
+			return false
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err = doWebhookRequest(req)
+		if err != nil {
+			failure = err.Error()
+			breakerRecord(host, 0, err)
+			withFields(logFields{"event_id": body.EventID, "error": err}).error("webhook_failed", "Error sending webhook request: %v", err)
+			return false
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || retries >= env.RateLimitRetries {
+			break
+		}
+		delay := retryAfter(resp)
+		resp.Body.Close()
+		if delay > maxRetryAfter {
+			status = resp.StatusCode
+			failure = fmt.Sprintf("rate limited for %v", delay)
+			breakerRecord(host, status, nil)
+			withFields(logFields{"event_id": body.EventID, "retry_after": delay.Seconds()}).error("webhook_failed", "Webhook rate limited for too long, giving up: %v", delay)
+			return false
+		}
+		retries++
+		if env.EnableLogger {
+			withFields(logFields{"event_id": body.EventID, "retry": retries, "retry_after": delay.Seconds()}).warn("webhook_retry", "Webhook rate limited, retrying in %v (%d/%d): %s", delay, retries, env.RateLimitRetries, maskWebhookURL(urlStr))
+		}
+		time.Sleep(delay)
+	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
+	breakerRecord(host, status, nil)
+	// A 404 on PATCH only means the ticker message is gone, not the webhook
+	if method == "POST" {
+		recordWebhookStatus(urlStr, status)
+	}
 	if resp.StatusCode >= 400 {
-		log.Println("Webhook error, status code:", resp.StatusCode)
+		// The ticker message was deleted, post a new one next time
+		if method == "PATCH" && resp.StatusCode == http.StatusNotFound {
+			setTickerMessageID(urlStr, "")
+		}
+		withFields(logFields{"event_id": body.EventID, "status": resp.StatusCode}).error("webhook_failed", "Webhook error, status code: %d", resp.StatusCode)
 		return false
 	}
+	markPosted(urlStr, body.Tag)
+	if wait {
+		var msg WebhookMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err == nil && msg.ID != "" {
+			if env.TickerMode && method == "POST" {
+				setTickerMessageID(urlStr, msg.ID)
+			}
+			if env.MessageLinks {
+				link = messageLink(urlStr, msg)
+				withFields(logFields{"event_id": body.EventID, "link": link}).info("webhook_posted", "Posted message: %s", link)
+			}
+		}
+	}
 	return true
 }
 
-func sendMessage(body MessageBody) error {
-	if env.DiscordWebhookURL == "" {
-		return nil
+// Wrap the JSON payload and a file into a multipart/form-data body, as
+// Discord expects for uploads: payload_json plus files[0]
+func multipartPayload(payload []byte, file Attachment) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormField("payload_json")
+	if err != nil {
+		return nil, "", err
 	}
-	webhookUrls := strings.Split(env.DiscordWebhookURL, ",")
-	// If target prefectures are set, check if the message contains any of them
-	if len(env.TargetPrefectures) > 0 {
-		var affected []string
-		for _, field := range body.Fields {
-			parts := strings.Split(field.Value, ", ")
-			affected = append(affected, parts...)
-		}
-		shouldSend := false
-		for _, target := range env.TargetPrefectures {
-			for _, a := range affected {
-				if a == target {
-					shouldSend = true
-					break
-				}
-			}
-			if shouldSend {
-				break
-			}
+	part.Write(payload)
+	part, err = w.CreateFormFile("files[0]", file.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(file.Data)
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// Mentions are suppressed when every affected prefecture is in NoMentionPrefectures
+func onlyNoMentionAffected(affected []string) bool {
+	env := currentEnv()
+	if len(env.NoMentionPrefectures) == 0 || len(affected) == 0 {
+		return false
+	}
+	for _, a := range affected {
+		if !containsString(env.NoMentionPrefectures, a) {
+			return false
 		}
-		if !shouldSend {
-			if env.EnableLogger {
-				log.Println("No target prefectures affected, skipping webhook")
+	}
+	return true
+}
+
+// With BASELINE_INTENSITY set, report whether no configured prefecture was
+// shaken harder than its baseline (prefectures without a baseline are ignored)
+func withinBaseline(groups []PointGroup) bool {
+	env := currentEnv()
+	if len(env.BaselineIntensity) == 0 {
+		return false
+	}
+	for _, g := range groups {
+		for _, region := range g.Regions {
+			if baseline, ok := env.BaselineIntensity[region]; ok && g.ScaleInt > baseline {
+				return false
 			}
+		}
+	}
+	return true
+}
+
+// With MENTION_MIN_MAGNITUDE set, only quakes of at least that magnitude
+// mention. MENTION_MIN_SCALE and MENTION_TIERS do the same by intensity;
+// tsunami warnings are not held to it. When
+// both kinds are set, meeting either one is enough.
+func meetsMentionThreshold(body MessageBody) bool {
+	env := currentEnv()
+	byScale := env.MentionMinScale > 0 || len(env.MentionTiers) > 0
+	scaleMet := false
+	if byScale {
+		_, inTier := matchMentionTier(body, env.MentionTiers)
+		scaleMet = inTier && (body.Tsunami || body.MaxScale >= env.MentionMinScale)
+	}
+	switch {
+	case env.MentionMinMagnitude > 0 && byScale:
+		return scaleMet || body.Magnitude >= env.MentionMinMagnitude
+	case env.MentionMinMagnitude > 0:
+		return body.Magnitude >= env.MentionMinMagnitude
+	case byScale:
+		return scaleMet
+	}
+	return true
+}
+
+func sendMessage(body MessageBody) error {
+	env := currentEnv()
+	if body.Tag.Seq == 0 {
+		body.Tag = nextSendTag()
+	}
+	if suppressForMaintenance(body) {
+		return nil
+	}
+	affected := body.Prefectures
+	// Destinations limited to prefectures only receive matching quakes
+	dests := destinationsFor(affected)
+	if body.Drill && env.DrillWebhookURL != "" {
+		dests = webhookDestinations(env.SinkType, env.DrillWebhookURL)
+	}
+	// Sandbox data is always labelled, and only reaches the production
+	// webhooks with ALLOW_SANDBOX_TO_PROD=true
+	if body.Sandbox {
+		body.Title = fmt.Sprintf(tr("sandbox_title"), body.Title)
+		if env.DevWebhookURL != "" {
+			dests = webhookDestinations(env.SinkType, env.DevWebhookURL)
+		} else if !env.AllowSandboxToProd && !env.DryRun {
+			logThrottled("warn", "sandbox", "Sandbox event not posted: set DEV_WEBHOOK_URL or ALLOW_SANDBOX_TO_PROD=true")
 			return nil
 		}
 	}
+	if len(dests) == 0 {
+		return nil
+	}
+	mention := mentionsEnabled(env) && !onlyNoMentionAffected(affected) && meetsMentionThreshold(body)
+	if env.UpgradeRealert && body.QuakeKey != "" {
+		mention = upgradeMention(body, mention)
+	}
+	if env.DryRun {
+		for i, d := range dests {
+			dryRunDestination(d, debugFooter(body, i, len(dests), 0), mention)
+		}
+		return nil
+	}
 	successCount := 0
-	for _, url := range webhookUrls {
-		url = strings.TrimSpace(url)
-		if !sendWebhook(body, url) {
-			log.Println("Failed to send webhook:", url)
+	total := len(dests)
+	for i, d := range dests {
+		if !sendToDestination(d, debugFooter(body, i, total, successCount), mention) {
+			withFields(logFields{"event_id": body.EventID}).error("webhook_failed", "Failed to send to %s", d)
 		} else {
 			successCount++
 		}
 	}
 	if env.EnableLogger {
-		log.Printf("Webhook sent (%d/%d)\n", successCount, len(webhookUrls))
+		withFields(logFields{"event_id": body.EventID, "success": successCount, "total": total}).info("webhook_sent", "Webhook sent (%d/%d)", successCount, total)
+	}
+	if successCount == 0 {
+		saveDeadLetter(newDeadLetter(body, dests, mention))
+	} else {
+		redeliverDeadLetters()
 	}
 	return nil
 }
 
+// With DEBUG_FOOTER, note the delivery state in the footer. Destinations are
+// posted to in turn, so each message counts the deliveries that succeeded
+// before it; the last one shows all but its own.
+func debugFooter(body MessageBody, index, total, delivered int) MessageBody {
+	env := currentEnv()
+	if !env.DebugFooter {
+		return body
+	}
+	dedup := body.Dedup
+	if dedup == "" {
+		dedup = "not deduplicated"
+	}
+	body.Footer = &MessageFooter{
+		Text: fmt.Sprintf("instance: %s · destination %d/%d · delivered before: %d · dedup: %s (%d tracked)", env.InstanceName, index+1, total, delivered, dedup, seenCount()),
+	}
+	return body
+}
+
+// Format a count as an English ordinal (1st, 2nd, 3rd, 4th, 11th, ...)
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 100 {
+	case 11, 12, 13:
+	default:
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// Drills are flagged as tests or announced as training (訓練) in the comment
+func isDrill(eq JMAQuake) bool {
+	return eq.Test || strings.Contains(eq.Comments.FreeFormComment, "訓練")
+}
+
 func handleEarthquake(eq JMAQuake, isDev bool) {
+	env := currentEnv()
+	metricQuakesProcessed.Add(1)
+	now := time.Now()
+	drill := isDrill(eq)
+	swarmCount := 0
+	if !drill {
+		swarmCount = recordQuake(eq, now)
+		noteP2PQuake(eq)
+	}
 	groups := parsePoints(eq.Points)
-	t := eq.Earthquake.Time
+	appendEventCSV(eq, groups)
+	in := filterInput{Quake: eq, Groups: groups, Drill: drill, Now: now}
+	if pass, name, reason := runFilterChain(buildFilterChain(env.Filters), in); !pass {
+		if env.EnableLogger {
+			withFields(logFields{"event_id": eq.ID, "scale": eq.Earthquake.MaxScale, "filter": name}).info("earthquake_skipped", "Skipping earthquake (%s filter): %s", name, reason)
+		}
+		return
+	}
+	// Still needed when FILTERS leaves out the scale filter
 	scale, ok := parseScale(eq.Earthquake.MaxScale)
 	if !ok {
-		log.Println("Earthquake scale is undefined.")
+		withFields(logFields{"event_id": eq.ID}).warn("earthquake_skipped", "Earthquake scale is undefined.")
 		return
 	}
-	body := createEarthquakeMessage(t, scale, groups, isDev)
+	body := createEarthquakeMessage(eq, scale, groups, isDev)
+	body.ThreadName = forumThreadName(eq)
+	body.EventID = eq.ID
+	body.Sandbox = isDev
+	body.Dedup = quakeDedup(eq)
+	body.MaxScale = eq.Earthquake.MaxScale
+	if h := eq.Earthquake.Hypocenter; h != nil && h.Magnitude > 0 {
+		body.Magnitude = h.Magnitude
+	}
+	if env.SwarmNote && swarmCount > 1 {
+		body.Description += fmt.Sprintf(tr("swarm_note"), localOrdinal(swarmCount))
+	}
+	if env.AttachPointsThreshold > 0 && len(eq.Points) >= env.AttachPointsThreshold {
+		if attachment, err := pointsCSV(eq.Points); err != nil {
+			logError("earthquake", "Error encoding points CSV: %v", err)
+		} else {
+			body.Attachment = &attachment
+		}
+	}
+	if env.UpgradeRealert {
+		body.QuakeKey = eq.Earthquake.Time
+		if prev, ok := lastPosted(body.QuakeKey); ok && body.MaxScale > prev.MaxScale {
+			body.Upgrade = true
+			from, _ := parseScale(prev.MaxScale)
+			body.Description = fmt.Sprintf(tr("upgraded"), from, scale) + body.Description
+		}
+	}
+	if isCorrection(eq) {
+		markCorrection(&body, eq)
+	}
+	if drill {
+		body.Drill = true
+		body.Description = tr("drill") + body.Description
+	}
 	if err := sendMessage(body); err != nil {
-		log.Println("Error sending message:", err)
+		withFields(logFields{"event_id": eq.ID}).error("earthquake", "Error sending message: %v", err)
 	} else if env.EnableLogger {
-		log.Println("Earthquake alert received and posted successfully.")
+		withFields(logFields{"event_id": eq.ID, "scale": eq.Earthquake.MaxScale}).info("earthquake_posted", "Earthquake alert received and posted successfully.")
 	}
+	// Only events that passed the filters reach the generic sink
+	event := normalizeQuake(eq, groups)
+	event.Sandbox = isDev
+	sendGeneric(event)
 }
 
 //────────────────────────────
@@ -388,34 +1565,80 @@ func handleEarthquake(eq JMAQuake, isDev bool) {
 
 func onMessage(message []byte, isDev bool) {
 	if isDev {
-		log.Println("Message received from server.")
+		logThrottled("info", "received", "Message received from server.")
 	}
 	// Parse to a generic map once to check the code
 	var data map[string]interface{}
 	if err := json.Unmarshal(message, &data); err != nil {
-		log.Println("Error parsing message:", err)
+		logError("message_invalid", "Error parsing message: %v", err)
 		return
 	}
 	code, ok := data["code"].(float64)
 	if !ok {
-		log.Println("Message does not contain a valid code")
+		logWarn("message_invalid", "Message does not contain a valid code")
 		return
 	}
 	if int(code) == 551 {
-		var quake JMAQuake
-		if err := json.Unmarshal(message, &quake); err != nil {
-			log.Println("Error parsing earthquake message:", err)
+		// Decode leniently: a partial alert beats no alert
+		quake, failed, err := decodeQuake(message)
+		if err != nil {
+			logError("message_invalid", "Error parsing earthquake message: %v", err)
 			return
 		}
-		handleEarthquake(quake, isDev)
+		if len(failed) > 0 {
+			withFields(logFields{"event_id": quake.ID}).warn("message_invalid", "Earthquake message %s has malformed fields, continuing without them: %s", quake.ID, strings.Join(failed, ", "))
+		}
+		if err := validateQuake(quake); err != nil {
+			withFields(logFields{"event_id": quake.ID}).warn("message_invalid", "Skipping invalid earthquake message %s: %v", quake.ID, err)
+			return
+		}
+		recordEventTime(quake.Time)
+		if !markSeen(quake.ID) {
+			if isDev {
+				logThrottled("info", "duplicate", "Duplicate event, skipping: %s", quake.ID)
+			}
+			return
+		}
+		if currentEnv().EnableLogger {
+			withFields(logFields{"event_id": quake.ID, "scale": quake.Earthquake.MaxScale, "type": quake.Issue.Type}).info("earthquake_received", "Earthquake report received: %s (%s)", quake.ID, quake.Issue.Type)
+		}
+		aggregateQuake(quake, isDev)
+	} else if int(code) == 552 {
+		var tsunami JMATsunami
+		if err := json.Unmarshal(message, &tsunami); err != nil {
+			logError("message_invalid", "Error parsing tsunami message: %v", err)
+			return
+		}
+		tsunami.fillID()
+		recordEventTime(tsunami.Time)
+		if !markSeen(tsunami.ID) {
+			return
+		}
+		handleTsunami(tsunami, isDev)
+		event := normalizeTsunami(tsunami)
+		event.Sandbox = isDev
+		sendGeneric(event)
 	} else {
 		if isDev {
-			log.Println("Unknown message code:", code)
+			logThrottled("info", "unknown-code", "Unknown message code: %v", code)
 		}
 	}
 }
 
-func connectAndHandle(isDev bool) error {
+// Slots limiting concurrent outbound connection attempts: WebSocket dials and
+// history fetches for polling and replay (RECONNECT_CONCURRENCY, 1 by default)
+var dialSlots = make(chan struct{}, 1)
+
+// Wait for a dial slot; the returned function releases it
+func acquireDial() func() {
+	dialSlots <- struct{}{}
+	return func() { <-dialSlots }
+}
+
+// connectAndHandle reports whether the connection was opened before it failed,
+// so that the caller can tell dial failures apart from dropped connections,
+// and how long it stayed up. Cancelling ctx closes the connection cleanly.
+func connectAndHandle(ctx context.Context, isDev bool) (bool, time.Duration, error) {
 	var wsURL string
 	if isDev {
 		wsURL = "wss://api-realtime-sandbox.p2pquake.net/v2/ws"
@@ -423,77 +1646,272 @@ func connectAndHandle(isDev bool) error {
 		wsURL = "wss://api.p2pquake.net/v2/ws"
 	}
 
-	log.Println("Connecting to", wsURL)
-	c, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	logInfo("connecting", "Connecting to %s", wsURL)
+	release := acquireDial()
+	c, resp, err := wsDialer.Dial(wsURL, nil)
+	release()
 
 	if err != nil {
 		if resp != nil {
-			return fmt.Errorf("%v (HTTP %d)", err, resp.StatusCode)
+			return false, 0, fmt.Errorf("%v (HTTP %d)", err, resp.StatusCode)
 		}
-		return err
+		return false, 0, err
 	}
 
 	defer c.Close()
-	log.Println("WebSocket connection opened.")
+	metricConnected.Store(true)
+	defer metricConnected.Store(false)
+	logInfo("connected", "WebSocket connection opened.")
+	opened := time.Now()
+	go replayMissed(isDev)
+
+	// Without traffic or pongs for PONG_TIMEOUT, the read fails and we reconnect
+	env := currentEnv()
+	if env.PongTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(env.PongTimeout))
+		c.SetPongHandler(func(string) error {
+			noteActivity()
+			return c.SetReadDeadline(time.Now().Add(env.PongTimeout))
+		})
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if env.PingInterval > 0 {
+		go keepAlive(c, env.PingInterval, done)
+	}
+	go closeOnCancel(ctx, c, done)
 
 	// Loop to receive messages
 	for {
 		_, msg, err := c.ReadMessage()
+		if ctx.Err() != nil {
+			return true, time.Since(opened), nil
+		}
 		if err != nil {
-			return err
+			return true, time.Since(opened), err
+		}
+		if env.PongTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(env.PongTimeout))
 		}
-		// Process each message in a separate goroutine
-		go onMessage(msg, isDev)
+		dispatchMessage(msg, isDev)
 	}
 }
 
-func main() {
-	loadEnv()
-
-	// Check DISCORD_WEBHOOK_URL
-	if env.DiscordWebhookURL == "" {
-		log.Fatal("DISCORD_WEBHOOK_URL is not set.")
-	} else {
-		valid := true
-		urls := strings.Split(env.DiscordWebhookURL, ",")
-		for _, u := range urls {
-			u = strings.TrimSpace(u)
-			if !strings.HasPrefix(u, "https://discord.com/api/webhooks/") {
-				valid = false
-				break
+// Ping the server every interval until done is closed. A failed ping is only
+// logged; the read deadline in connectAndHandle notices the dead link.
+func keepAlive(c *websocket.Conn, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				logThrottled("warn", "ping", "WebSocket ping failed: %v", err)
 			}
 		}
-		if !valid {
-			log.Fatal("DISCORD_WEBHOOK_URL is not valid.")
+	}
+}
+
+// On shutdown, send a close frame and give the server a moment to answer
+// before the pending read is cut off
+func closeOnCancel(ctx context.Context, c *websocket.Conn, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shutting down")
+		if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+			logWarn("shutdown", "Error sending WebSocket close frame: %v", err)
 		}
+		c.SetReadDeadline(time.Now().Add(time.Second))
+	}
+}
+
+const (
+	baseReconnectDelay = 5 * time.Second
+	maxReconnectDelay  = 30 * time.Second
+	// A connection that stayed up this long resets the backoff
+	stableConnection = 60 * time.Second
+)
+
+// Exponential backoff (base * 2^attempts) capped at maxReconnectDelay.
+// The cap is checked in floating point so large attempt counts cannot overflow.
+func reconnectDelay(attempts int) time.Duration {
+	if attempts < 0 {
+		attempts = 0
+	}
+	delay := float64(baseReconnectDelay) * math.Pow(2, float64(attempts))
+	if delay >= float64(maxReconnectDelay) {
+		return maxReconnectDelay
 	}
+	return time.Duration(delay)
+}
+
+func isValidWebhookURL(u string) bool {
+	return strings.HasPrefix(u, "https://discord.com/api/webhooks/")
+}
+
+// Webhook metadata returned by a GET on the webhook URL
+type WebhookInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+}
+
+// Look up a webhook to confirm it exists and is reachable
+func fetchWebhookInfo(urlStr string) (WebhookInfo, error) {
+	var info WebhookInfo
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return info, err
+	}
+	resp, err := doWebhookRequest(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return info, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("decoding webhook metadata: %w", err)
+	}
+	return info, nil
+}
+
+// micro validate-webhook <url>
+func runValidateWebhook(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: micro validate-webhook <url>")
+		return 2
+	}
+	u := strings.TrimSpace(args[0])
+	if !isValidWebhookURL(u) {
+		fmt.Fprintln(os.Stderr, "Webhook URL is not valid:", u)
+		return 1
+	}
+	info, err := fetchWebhookInfo(u)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Webhook check failed:", err)
+		return 1
+	}
+	fmt.Printf("Webhook OK: %q (id %s) posts to channel %s in guild %s\n", info.Name, info.ID, info.ChannelID, info.GuildID)
+	return 0
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-webhook" {
+		os.Exit(runValidateWebhook(os.Args[2:]))
+	}
+	if err := parseFlags(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	if err := loadEnv(); err != nil {
+		logFatal("config", "%v", err)
+	}
+	env := currentEnv()
+
+	startWorkers(env.MaxConcurrentMessages)
+	// Messages kept from a previous outage are sent again right away
+	redeliverDeadLetters()
+	dialSlots = make(chan struct{}, env.ReconnectConcurrency)
+
+	// Reload the configuration on SIGHUP without dropping the connection.
+	// Startup-only settings (mode, transport, ports, concurrency, aggregation,
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY) keep their values.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := loadEnv(); err != nil {
+				logError("config_reload", "Configuration not reloaded, keeping the current one: %v", err)
+				continue
+			}
+			logInfo("config_reloaded", "Configuration reloaded.")
+		}
+	}()
+
+	// SIGINT/SIGTERM stop the connection loop; in-flight alerts are then given
+	// SHUTDOWN_TIMEOUT to finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	isDev := env.RunMode == "development"
-	log.Printf("Now running in %s mode.\n", func() string {
+	if env.DryRun {
+		logInfo("startup", "Dry run: messages are logged, not posted.")
+	} else if isDev && env.DevWebhookURL == "" && !env.AllowSandboxToProd {
+		logWarn("config", "Warning: development mode posts nothing until DEV_WEBHOOK_URL or ALLOW_SANDBOX_TO_PROD=true is set.")
+	}
+	logInfo("startup", "Now running in %s mode.", func() string {
 		if isDev {
 			return "development"
 		}
 		return "production"
 	}())
 
+	loadState()
+	quakeAggregator = newQuakeAggregator(isDev)
+	registerControlRoutes(isDev)
+	registerFanout()
+	registerMetrics()
+	registerHealth()
+	startHTTPServers()
+	// JMA publishes production data only, so there is nothing to compare the sandbox with
+	if env.JMACrossCheck && !isDev {
+		go runCrossCheck(isDev)
+	}
+
+	defer shutdown()
+
+	if env.Transport == "poll" {
+		runPolling(ctx, isDev)
+		return
+	}
+
 	reconnectAttempts := 0
-	baseReconnectDelay := 5 * time.Second
-	maxReconnectDelay := 30 * time.Second
+
+	// Until the first connection succeeds, retry quickly with a separate budget
+	everConnected := false
+	initialAttempts := 0
 
 	// WebSocket connection and reconnection loop
 	for {
-		err := connectAndHandle(isDev)
+		opened, uptime, err := connectAndHandle(ctx, isDev)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			log.Println("WebSocket connection error:", err)
+			withFields(logFields{"error": err}).error("disconnected", "WebSocket connection error: %v", err)
+		}
+		if opened {
+			everConnected = true
+		}
+		// A drop after a healthy connection is transient: recover quickly
+		if uptime >= stableConnection {
+			reconnectAttempts = 0
+		}
+		if !everConnected && initialAttempts < env.InitialConnectRetries {
+			initialAttempts++
+			withFields(logFields{"attempt": initialAttempts}).warn("reconnect", "Initial connection failed, retrying in %v (%d/%d)...", env.InitialConnectDelay, initialAttempts, env.InitialConnectRetries)
+			if !sleepContext(ctx, env.InitialConnectDelay) {
+				return
+			}
+			continue
 		}
 		// Exponential backoff
-		delay := time.Duration(float64(baseReconnectDelay) * math.Pow(2, float64(reconnectAttempts)))
-		if delay > maxReconnectDelay {
-			delay = maxReconnectDelay
+		delay := reconnectDelay(reconnectAttempts)
+		withFields(logFields{"attempt": reconnectAttempts + 1, "delay": delay.Seconds()}).info("reconnect", "Reconnecting in %v...", delay)
+		if !sleepContext(ctx, delay) {
+			return
 		}
-		log.Printf("Reconnecting in %v...\n", delay)
-		time.Sleep(delay)
 		reconnectAttempts++
-		log.Println("Attempting to reconnect...")
+		metricReconnectAttempts.Add(1)
+		logInfo("reconnect", "Attempting to reconnect...")
 	}
 }
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{"first attempt", 0, 5 * time.Second},
+		{"second attempt", 1, 10 * time.Second},
+		{"third attempt", 2, 20 * time.Second},
+		{"reaches cap", 3, 30 * time.Second},
+		{"stays at cap", 10, 30 * time.Second},
+		{"negative attempts", -1, 5 * time.Second},
+		{"very large attempts", 10000, 30 * time.Second},
+		{"max int attempts", math.MaxInt, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reconnectDelay(tt.attempts)
+			if got != tt.want {
+				t.Errorf("reconnectDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+			if got <= 0 {
+				t.Errorf("reconnectDelay(%d) returned non-positive duration %v", tt.attempts, got)
+			}
+		})
+	}
+}
+
+// Groups reduced to comparable values, with the regions of each group sorted
+type groupSummary struct {
+	Scale   int
+	Label   string
+	Regions []string
+	Points  int
+}
+
+func summarize(groups []PointGroup) []groupSummary {
+	var out []groupSummary
+	for _, g := range groups {
+		regions := append([]string(nil), g.Regions...)
+		sort.Strings(regions)
+		out = append(out, groupSummary{g.ScaleInt, g.ScaleStr, regions, len(g.Points)})
+	}
+	return out
+}
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		want   []groupSummary
+	}{
+		{
+			name: "empty input",
+			want: nil,
+		},
+		{
+			name: "highest scale per prefecture wins",
+			points: []Point{
+				{Pref: "宮城県", Addr: "仙台市青葉区", Scale: 30},
+				{Pref: "宮城県", Addr: "石巻市", Scale: 50},
+				{Pref: "宮城県", Addr: "気仙沼市", Scale: 40},
+			},
+			want: []groupSummary{{50, "5 strong", []string{"Miyagi"}, 3}},
+		},
+		{
+			name: "grouped by scale and sorted from the lowest",
+			points: []Point{
+				{Pref: "東京都", Scale: 30},
+				{Pref: "千葉県", Scale: 45},
+				{Pref: "神奈川県", Scale: 30},
+				{Pref: "埼玉県", Scale: 10},
+			},
+			want: []groupSummary{
+				{10, "1", []string{"Saitama"}, 1},
+				{30, "3", []string{"Kanagawa", "Tokyo"}, 2},
+				{45, "5 weak", []string{"Chiba"}, 1},
+			},
+		},
+		{
+			name: "unknown scale codes are dropped",
+			points: []Point{
+				{Pref: "北海道", Scale: 46},
+				{Pref: "青森県", Scale: -1},
+				{Pref: "岩手県", Scale: 20},
+			},
+			want: []groupSummary{{20, "2", []string{"Iwate"}, 1}},
+		},
+		{
+			name: "an unknown scale does not hide a known one in the same prefecture",
+			points: []Point{
+				{Pref: "長野県", Scale: 99},
+				{Pref: "長野県", Scale: 40},
+			},
+			want: []groupSummary{{40, "4", []string{"Nagano"}, 1}},
+		},
+		{
+			name: "area entries yield to station observations in the same prefecture",
+			points: []Point{
+				{Pref: "石川県", Addr: "石川県能登", Scale: 70, IsArea: true},
+				{Pref: "石川県", Addr: "輪島市", Scale: 60},
+				{Pref: "石川県", Addr: "七尾市", Scale: 55},
+				{Pref: "石川県", Addr: "金沢市", Scale: 50},
+			},
+			want: []groupSummary{{60, "6 strong", []string{"Ishikawa"}, 3}},
+		},
+		{
+			name: "area entries count where no station reported",
+			points: []Point{
+				{Pref: "富山県", Addr: "富山県東部", Scale: 50, IsArea: true},
+				{Pref: "新潟県", Addr: "上越市", Scale: 45},
+			},
+			want: []groupSummary{
+				{45, "5 weak", []string{"Niigata"}, 1},
+				{50, "5 strong", []string{"Toyama"}, 1},
+			},
+		},
+		{
+			name:   "untranslated names are kept as is",
+			points: []Point{{Pref: "未知の地域", Scale: 10}},
+			want:   []groupSummary{{10, "1", []string{"未知の地域"}, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarize(parsePoints(tt.points)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePoints = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScale(t *testing.T) {
+	tests := []struct {
+		scale int
+		want  string
+		ok    bool
+	}{
+		{-1, "", false},
+		{0, "", false},
+		{9, "", false},
+		{10, "1", true},
+		{11, "", false},
+		{45, "5 weak", true},
+		{46, "", false},
+		{70, "7", true},
+		{71, "", false},
+		{100, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseScale(tt.scale)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseScale(%d) = (%q, %v), want (%q, %v)", tt.scale, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCreateEarthquakeMessageTime(t *testing.T) {
+	withEnv(t, Env{Language: "en"})
+	var eq JMAQuake
+	eq.Earthquake.MaxScale = 40
+
+	eq.Earthquake.Time = "2024/01/01 16:10:09"
+	body := createEarthquakeMessage(eq, "4", nil, false)
+	if !strings.Contains(body.Description, "16:10:09 on 2024/01/01") {
+		t.Errorf("description %q does not carry the origin time", body.Description)
+	}
+
+	// A malformed timestamp falls back to the current time
+	eq.Earthquake.Time = "2024-01-01T16:10:09"
+	before := time.Now()
+	body = createEarthquakeMessage(eq, "4", nil, false)
+	after := time.Now()
+	if !strings.Contains(body.Description, before.Format("2006/01/02")) && !strings.Contains(body.Description, after.Format("2006/01/02")) {
+		t.Errorf("description %q does not carry today's date", body.Description)
+	}
+	if strings.Contains(body.Description, "2024-01-01") {
+		t.Errorf("description %q carries the malformed timestamp", body.Description)
+	}
+}
+
+func TestCreateEarthquakeMessageFitsDiscordLimits(t *testing.T) {
+	withEnv(t, Env{Language: "en", Fields: []string{"epicenter", "magnitude", "depth", "intensity-groups", "point-count", "cities"}})
+	scales := []int{10, 20, 30, 40, 45, 50, 55, 60, 70}
+	var eq JMAQuake
+	eq.Earthquake.Time = "2024/01/01 16:10:09"
+	eq.Earthquake.MaxScale = 70
+	prefs := 0
+	for name := range translateMap {
+		if name != "北海道" && !strings.HasSuffix(name, "県") && !strings.HasSuffix(name, "府") && !strings.HasSuffix(name, "都") {
+			continue
+		}
+		// Enough cities per prefecture to overflow a single field value
+		for i := 0; i < 80; i++ {
+			eq.Points = append(eq.Points, Point{Pref: name, Addr: fmt.Sprintf("%s市町村%02d", name, i), Scale: scales[prefs%len(scales)]})
+		}
+		prefs++
+	}
+	if prefs != 47 {
+		t.Fatalf("found %d prefectures, want 47", prefs)
+	}
+
+	body := createEarthquakeMessage(eq, "7", parsePoints(eq.Points), false)
+	if len(body.Fields) > maxEmbedFields {
+		t.Errorf("%d fields, want at most %d", len(body.Fields), maxEmbedFields)
+	}
+	total := utf8.RuneCountInString(body.Title) + utf8.RuneCountInString(body.Description)
+	for _, f := range body.Fields {
+		if n := utf8.RuneCountInString(f.Value); n > maxFieldValue {
+			t.Errorf("field %q has %d characters, want at most %d", f.Name, n, maxFieldValue)
+		}
+		total += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	if total > 6000 {
+		t.Errorf("embed has %d characters, want at most 6000", total)
+	}
+	last := body.Fields[len(body.Fields)-1]
+	if last.Name != "Other Prefectures" || !strings.Contains(last.Value, "more prefectures") {
+		t.Errorf("last field %+v does not summarize the left-out prefectures", last)
+	}
+	// The highest intensity keeps its details, and every prefecture is still listed
+	listed := 0
+	var strongest string
+	for _, f := range body.Fields {
+		if strings.HasPrefix(f.Name, "Seismic Intensity") {
+			listed += len(strings.Split(f.Value, ", "))
+			strongest = f.Value
+		}
+	}
+	if listed != 47 {
+		t.Errorf("intensity groups list %d prefectures, want 47", listed)
+	}
+	for _, f := range body.Fields {
+		if pref, ok := strings.CutPrefix(f.Name, "Cities in "); ok && !strings.Contains(strongest, pref) {
+			t.Errorf("details kept for %s, which is not among the strongest shaking (%s)", pref, strongest)
+		}
+	}
+}
+
+func TestMentionTiers(t *testing.T) {
+	tiers := parseMentionTiers("5 weak=111, 6 weak=everyone, bogus", baseScaleMap("en"))
+	withEnv(t, Env{Language: "en", DiscordMentionEnabled: true, MentionRoleID: "999", MentionTiers: tiers})
+	tests := []struct {
+		name     string
+		maxScale int
+		tsunami  bool
+		mention  bool
+		text     string
+	}{
+		{"below every tier", 40, false, false, ""},
+		{"lower tier", 50, false, true, "<@&111>"},
+		{"upper tier", 60, false, true, "@everyone"},
+		{"tsunami", 0, true, true, "@everyone"},
+		{"no intensity (notice)", 0, false, false, ""},
+	}
+	for _, tt := range tests {
+		body := MessageBody{MaxScale: tt.maxScale, Tsunami: tt.tsunami}
+		if got := meetsMentionThreshold(body); got != tt.mention {
+			t.Errorf("%s: meetsMentionThreshold = %v, want %v", tt.name, got, tt.mention)
+		}
+		if tt.mention {
+			if got := mentionText(body, true); got != tt.text {
+				t.Errorf("%s: mentionText = %q, want %q", tt.name, got, tt.text)
+			}
+		}
+	}
+}
+
+// Every threshold turns mentions on without DISCORD_MENTION_ENABLED
+func TestMentionThresholdsEnableMentions(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       Env
+		magnitude float64
+		enabled   bool
+		mention   bool
+	}{
+		{"nothing set", Env{}, 7.0, false, false},
+		{"master switch", Env{DiscordMentionEnabled: true}, 3.0, true, true},
+		{"intensity", Env{MentionMinScale: 50}, 0, true, false},
+		{"tiers", Env{MentionTiers: []mentionTier{{MinScale: 50}}}, 0, true, false},
+		{"magnitude met", Env{MentionMinMagnitude: 6.0}, 6.5, true, true},
+		{"magnitude not met", Env{MentionMinMagnitude: 6.0}, 5.0, true, false},
+	}
+	for _, tt := range tests {
+		withEnv(t, tt.env)
+		body := MessageBody{MaxScale: 40, Magnitude: tt.magnitude}
+		if got := mentionsEnabled(tt.env); got != tt.enabled {
+			t.Errorf("%s: mentionsEnabled = %v, want %v", tt.name, got, tt.enabled)
+		}
+		if got := mentionsEnabled(tt.env) && meetsMentionThreshold(body); got != tt.mention {
+			t.Errorf("%s: mention = %v, want %v", tt.name, got, tt.mention)
+		}
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	dests := parseDestinations("discord:https://discord.com/api/webhooks/1/a?thread_id=2, 東京都|osaka=slack:https://hooks.slack.com/services/T/B/x, telegram:123:ABC/-100, fax:1234, telegram:nochat")
+	want := []Destination{
+		{Type: "discord", URL: "https://discord.com/api/webhooks/1/a?thread_id=2"},
+		{Type: "slack", URL: "https://hooks.slack.com/services/T/B/x", Prefectures: []string{"Tokyo", "Osaka"}},
+		{Type: "telegram", BotToken: "123:ABC", ChatID: "-100"},
+	}
+	if !reflect.DeepEqual(dests, want) {
+		t.Fatalf("parseDestinations = %+v, want %+v", dests, want)
+	}
+
+	routes := parseRoutes("tokyo|大阪=https://discord.com/api/webhooks/4/d, Tokio=https://discord.com/api/webhooks/5/e")
+	if got, want := routes[0].Prefectures, []string{"Tokyo", "Osaka"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("route prefectures = %v, want %v", got, want)
+	}
+	if got, want := parseBaselines("tokyo:30, 大阪府:40, Tokio:20"), map[string]int{"Tokyo": 30, "Osaka": 40}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBaselines = %v, want %v", got, want)
+	}
+
+	// The older variables keep working next to DESTINATIONS
+	withEnv(t, Env{SinkType: "discord", Destinations: dests, DiscordWebhookURL: "https://discord.com/api/webhooks/3/c"})
+	kinds := func(dests []Destination) []string {
+		var out []string
+		for _, d := range dests {
+			out = append(out, d.Type)
+		}
+		return out
+	}
+	if got, want := kinds(destinationsFor([]string{"Miyagi"})), []string{"discord", "telegram", "discord"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quake in Miyagi goes to %v, want %v", got, want)
+	}
+	if got, want := kinds(destinationsFor([]string{"Osaka"})), []string{"discord", "slack", "telegram", "discord"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("quake in Osaka goes to %v, want %v", got, want)
+	}
+}
+
+func TestDispatchWhenQueueFull(t *testing.T) {
+	prev := workerQueues
+	queue := make(chan messageJob, 1)
+	workerQueues = []chan messageJob{queue}
+	t.Cleanup(func() { workerQueues = prev })
+	take := func() messageJob {
+		job := <-queue
+		inFlight.Done()
+		return job
+	}
+
+	inFlight.Add(1)
+	queue <- messageJob{message: []byte(`{"code":555}`)}
+
+	// Other messages are dropped right away
+	dispatchMessage([]byte(`{"code":555,"id":"b"}`), false)
+	if len(queue) != 1 {
+		t.Fatalf("queue holds %d messages, want the dropped one left out", len(queue))
+	}
+
+	for _, code := range []int{551, 552} {
+		message := []byte(fmt.Sprintf(`{"code":%d,"id":"alert"}`, code))
+		done := make(chan struct{})
+		go func() {
+			dispatchMessage(message, false)
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatalf("code %d returned without being queued", code)
+		case <-time.After(20 * time.Millisecond):
+		}
+		take()
+		<-done
+		if got := take(); string(got.message) != string(message) {
+			t.Errorf("code %d: queued %s, want %s", code, got.message, message)
+		}
+		inFlight.Add(1)
+		queue <- messageJob{message: []byte(`{"code":555}`)}
+	}
+	take()
+}
+
+func TestDeadLetterKeepsWholeMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	withEnv(t, Env{DeadLetterFile: path, DeadLetterMaxBytes: 1 << 20})
+	body := MessageBody{
+		Title:       "Earthquake",
+		Description: "details",
+		EventID:     "abc",
+		ThreadName:  "M6.1 Chiba",
+		Prefectures: []string{"Chiba", "Tokyo"},
+		MaxScale:    45,
+		Tsunami:     true,
+		Magnitude:   6.1,
+		Attachment:  &Attachment{Name: "points.csv", Data: []byte("pref,scale\n")},
+	}
+	dests := []Destination{{Type: "discord", URL: "https://discord.com/api/webhooks/1/token", Prefectures: []string{"Chiba"}}}
+	saveDeadLetter(newDeadLetter(body, dests, true))
+
+	lines, err := readDeadLetters(path)
+	if err != nil || len(lines) != 1 {
+		t.Fatalf("readDeadLetters() = %d lines, %v; want 1", len(lines), err)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(lines[0], &letter); err != nil {
+		t.Fatal(err)
+	}
+	if got := letter.message(); !reflect.DeepEqual(got, body) {
+		t.Errorf("reloaded message = %+v, want %+v", got, body)
+	}
+	if !letter.Mention || !reflect.DeepEqual(letter.Destinations, dests) {
+		t.Errorf("reloaded mention %v, destinations %+v", letter.Mention, letter.Destinations)
+	}
+}
+
+func TestReloadKeepsConfigurationThatFailsValidation(t *testing.T) {
+	const webhook = "https://discord.com/api/webhooks/1/token"
+	withEnv(t, Env{DiscordWebhookURL: webhook, Language: "en"})
+	t.Setenv("SECRET_SOURCE", "file")
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"unresolved secret", "DISCORD_WEBHOOK_URL", "secret://" + filepath.Join(t.TempDir(), "missing")},
+		{"invalid URL", "DISCORD_WEBHOOK_URL", "https://example.com/hook"},
+		{"unknown color scheme", "COLOR_SCHEME", "rainbow"},
+	}
+	for _, tt := range tests {
+		t.Setenv("DISCORD_WEBHOOK_URL", webhook)
+		t.Setenv(tt.key, tt.value)
+		if err := loadEnv(); err == nil {
+			t.Errorf("%s: loadEnv() succeeded", tt.name)
+		}
+		if got := currentEnv().DiscordWebhookURL; got != webhook {
+			t.Errorf("%s: DiscordWebhookURL = %q after the failed reload, want %q", tt.name, got, webhook)
+		}
+	}
+}
+
+func TestMaskWebhookURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		want   string
+		secret string
+	}{
+		{"discord", "https://discord.com/api/webhooks/123/tok3n", "https://discord.com/api/webhooks/123/****", "tok3n"},
+		{"slack", "https://hooks.slack.com/services/T01/B02/s3cret", "https://hooks.slack.com/services/T01/B02/****", "s3cret"},
+		{"telegram", "https://api.telegram.org/bot123:AAsecret/sendMessage?chat_id=-100", "https://api.telegram.org/bot123:****?chat_id=-100", "AAsecret"},
+		{"teams", "https://example.webhook.office.com/webhookb2/abc@def/IncomingWebhook/s3cret/ghi", "", "s3cret"},
+		{"invalid", "not a url", "invalid-url", ""},
+	}
+	for _, tt := range tests {
+		got := maskWebhookURL(tt.url)
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s: maskWebhookURL = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.secret != "" && strings.Contains(got, tt.secret) {
+			t.Errorf("%s: maskWebhookURL = %q leaks the secret", tt.name, got)
+		}
+	}
+
+	// Destinations on one host stay apart, and each keeps its mask
+	a := maskWebhookURL("https://example.webhook.office.com/webhookb2/a/IncomingWebhook/x/y")
+	b := maskWebhookURL("https://example.webhook.office.com/webhookb2/b/IncomingWebhook/x/y")
+	if a == b || a != maskWebhookURL("https://example.webhook.office.com/webhookb2/a/IncomingWebhook/x/y") {
+		t.Errorf("masks %q and %q do not tell the webhooks apart stably", a, b)
+	}
+	if maskWebhookURL("https://api.telegram.org/bot1:s/sendMessage?chat_id=1") == maskWebhookURL("https://api.telegram.org/bot1:s/sendMessage?chat_id=2") {
+		t.Error("chats of one Telegram bot share a mask")
+	}
+}
+
+func TestDebugFooter(t *testing.T) {
+	withEnv(t, Env{DebugFooter: true, InstanceName: "staging"})
+	tests := []struct {
+		dedup string
+		want  []string
+	}{
+		{"redelivered", []string{"instance: staging", "destination 2/3", "delivered before: 1", "dedup: redelivered"}},
+		{"", []string{"dedup: not deduplicated"}},
+	}
+	for _, tt := range tests {
+		footer := debugFooter(MessageBody{Dedup: tt.dedup}, 1, 3, 1).Footer
+		if footer == nil {
+			t.Fatal("debugFooter set no footer")
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(footer.Text, want) {
+				t.Errorf("footer %q lacks %q", footer.Text, want)
+			}
+		}
+	}
+
+	withEnv(t, Env{})
+	if footer := debugFooter(MessageBody{}, 0, 1, 0).Footer; footer != nil {
+		t.Errorf("footer %q set without DEBUG_FOOTER", footer.Text)
+	}
+}
+
+// A 551 frame laid out as the P2PQuake JSON API v2 delivers it over the
+// WebSocket, with the ID under "id" and the older "_id" next to it
+const sampleQuakeFrame = `{"_id":"6620b1c2e3f4a5b6c7d8e9f0","code":551,` +
+	`"earthquake":{"domesticTsunami":"None","foreignTsunami":"Unknown",` +
+	`"hypocenter":{"depth":10,"latitude":35.7,"longitude":140.1,"magnitude":4.2,"name":"千葉県北西部"},` +
+	`"maxScale":30,"time":"2024/04/18 14:32:00"},` +
+	`"id":"6620b1c2e3f4a5b6c7d8e9f0",` +
+	`"issue":{"correct":"None","source":"気象庁","time":"2024/04/18 14:35:00","type":"DetailScale"},` +
+	`"points":[{"addr":"千葉市中央区","isArea":false,"pref":"千葉県","scale":30}],` +
+	`"time":"2024/04/18 14:35:08.512",` +
+	`"timestamp":{"convert":"2024/04/18 14:35:08.498","register":"2024/04/18 14:35:08.512"},` +
+	`"user_agent":"jmaxml-seis-parser-go, relay, register-api","ver":"20231023"}`
+
+func TestDecodeFrameID(t *testing.T) {
+	withEnv(t, Env{})
+	tests := []struct {
+		name  string
+		frame string
+	}{
+		{"id and _id", sampleQuakeFrame},
+		{"id only", strings.Replace(sampleQuakeFrame, `"_id":"6620b1c2e3f4a5b6c7d8e9f0",`, "", 1)},
+		{"_id only", strings.Replace(sampleQuakeFrame, `"id":"6620b1c2e3f4a5b6c7d8e9f0",`, "", 1)},
+	}
+	for _, tt := range tests {
+		quake, failed, err := decodeQuake([]byte(tt.frame))
+		if err != nil || len(failed) > 0 {
+			t.Fatalf("%s: decodeQuake failed: %v (fields %v)", tt.name, err, failed)
+		}
+		if quake.ID != "6620b1c2e3f4a5b6c7d8e9f0" {
+			t.Errorf("%s: ID = %q", tt.name, quake.ID)
+		}
+		if err := validateQuake(quake); err != nil {
+			t.Errorf("%s: validateQuake rejected the frame: %v", tt.name, err)
+		}
+	}
+}
+
+func TestLogThrottledFlushesOnTimer(t *testing.T) {
+	withEnv(t, Env{LogThrottleInterval: 30 * time.Millisecond})
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}))
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		throttleMu.Lock()
+		throttleState = make(map[string]*throttleEntry)
+		throttleMu.Unlock()
+	})
+	output := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.String()
+	}
+
+	for i := 0; i < 5; i++ {
+		logThrottled("info", "test:flush", "line %d", i)
+	}
+	if got := strings.Count(output(), "\n"); got != 1 {
+		t.Fatalf("logged %d lines at once, want 1:\n%s", got, output())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(output(), "line 4 (suppressed 4 similar)") {
+		if time.Now().After(deadline) {
+			t.Fatalf("suppressed count never flushed:\n%s", output())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// Replayed events go through the worker queues, oldest first
+func TestReplayQueuesMissedEvents(t *testing.T) {
+	withEnv(t, Env{})
+	prevClient, prevQueues := apiClient, workerQueues
+	queue := make(chan messageJob, 10)
+	workerQueues = []chan messageJob{queue}
+	apiClient = &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		history := `[
+			{"code":551,"id":"new","time":"2024/01/01 16:12:00.000","earthquake":{"time":"2024/01/01 16:11:00"}},
+			{"code":552,"id":"mid","time":"2024/01/01 16:11:00.000"},
+			{"code":551,"id":"old","time":"2024/01/01 16:00:00.000","earthquake":{"time":"2024/01/01 15:59:00"}}
+		]`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(history))}, nil
+	})}
+	seenMu.Lock()
+	prevLast := lastEventTime
+	lastEventTime = time.Date(2024, 1, 1, 16, 5, 0, 0, jst)
+	seenMu.Unlock()
+	t.Cleanup(func() {
+		apiClient, workerQueues = prevClient, prevQueues
+		seenMu.Lock()
+		lastEventTime = prevLast
+		seenMu.Unlock()
+	})
+
+	replayMissed(false)
+
+	var got []string
+	for len(queue) > 0 {
+		job := <-queue
+		inFlight.Done()
+		var basic BasicData
+		json.Unmarshal(job.message, &basic)
+		got = append(got, basic.ID)
+	}
+	if want := []string{"mid", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v, want %v", got, want)
+	}
+}
+
+// Dead webhooks are left out of dead letters, and a letter for them alone is dropped
+func TestDeadLetterSkipsDeadWebhooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	withEnv(t, Env{DeadLetterFile: path, DeadLetterMaxBytes: 1 << 20})
+	live := Destination{Type: "discord", URL: "https://discord.com/api/webhooks/1/live"}
+	dead := Destination{Type: "discord", URL: "https://discord.com/api/webhooks/2/deleted"}
+	chat := Destination{Type: "telegram", BotToken: "1:x", ChatID: "42"}
+	deadMu.Lock()
+	deadWebhooks[dead.URL] = true
+	deadMu.Unlock()
+	t.Cleanup(func() {
+		deadMu.Lock()
+		delete(deadWebhooks, dead.URL)
+		deadMu.Unlock()
+	})
+
+	saveDeadLetter(newDeadLetter(MessageBody{EventID: "dead-only"}, []Destination{dead}, false))
+	if lines, _ := readDeadLetters(path); len(lines) != 0 {
+		t.Fatalf("kept %d letter(s) for a dead webhook alone", len(lines))
+	}
+
+	saveDeadLetter(newDeadLetter(MessageBody{EventID: "mixed"}, []Destination{live, dead, chat}, false))
+	lines, _ := readDeadLetters(path)
+	if len(lines) != 1 {
+		t.Fatalf("kept %d letters, want 1", len(lines))
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(lines[0], &letter); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Destination{live, chat}; !reflect.DeepEqual(letter.Destinations, want) {
+		t.Errorf("destinations = %+v, want %+v", letter.Destinations, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Maintenance Mode (MAINTENANCE)
+//────────────────────────────
+
+// Most suppressed alerts listed in the summary posted when maintenance ends
+const maintenanceSummaryMax = 10
+
+type suppressedAlert struct {
+	Time  time.Time
+	Title string
+	// First line of the description
+	Summary string
+}
+
+var (
+	maintMu    sync.Mutex
+	suppressed []suppressedAlert
+)
+
+// While in maintenance, record the alert instead of posting it. Events are
+// still received, deduplicated and tracked as usual.
+func suppressForMaintenance(body MessageBody) bool {
+	if !currentEnv().Maintenance {
+		return false
+	}
+	summary, _, _ := strings.Cut(body.Description, "\n")
+	maintMu.Lock()
+	suppressed = append(suppressed, suppressedAlert{Time: time.Now(), Title: body.Title, Summary: summary})
+	maintMu.Unlock()
+	logThrottled("info", "maintenance", "Maintenance mode, not posting: %s", body.Title)
+	return true
+}
+
+// Switch maintenance at runtime (control endpoint); a later SIGHUP reload
+// applies MAINTENANCE again
+func setMaintenance(on bool) {
+	envMu.Lock()
+	was := activeEnv.Maintenance
+	activeEnv.Maintenance = on
+	envMu.Unlock()
+	maintenanceChanged(was, on)
+}
+
+func maintenanceChanged(was, on bool) {
+	if was == on {
+		return
+	}
+	if on {
+		logInfo("maintenance", "Maintenance mode started, alerts are not posted.")
+		return
+	}
+	logInfo("maintenance", "Maintenance mode ended.")
+	go postMaintenanceSummary()
+}
+
+// With MAINTENANCE_SUMMARY, post what was suppressed during maintenance
+func postMaintenanceSummary() {
+	maintMu.Lock()
+	alerts := suppressed
+	suppressed = nil
+	maintMu.Unlock()
+	if !currentEnv().MaintenanceSummary || len(alerts) == 0 {
+		return
+	}
+	var fields []MessageField
+	for i, a := range alerts {
+		if i == maintenanceSummaryMax {
+			fields = append(fields, MessageField{
+				Name:  "…",
+				Value: fmt.Sprintf(tr("maintenance_more"), len(alerts)-maintenanceSummaryMax),
+			})
+			break
+		}
+		value := strings.ReplaceAll(a.Summary, "**", "")
+		if value == "" {
+			value = "-"
+		}
+		fields = append(fields, MessageField{
+			Name:  a.Time.Format("15:04") + " " + a.Title,
+			Value: value,
+		})
+	}
+	body := MessageBody{
+		Title:       tr("maintenance_title"),
+		Description: fmt.Sprintf(tr("maintenance_summary"), len(alerts)),
+		Fields:      fields,
+	}
+	if err := sendMessage(body); err != nil {
+		logError("maintenance", "Error sending maintenance summary: %v", err)
+	}
+}
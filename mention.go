@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+//────────────────────────────
+// Mention Tiers (MENTION_MIN_SCALE, MENTION_ROLE_ID, MENTION_TIERS)
+//────────────────────────────
+
+// Who is pinged from an intensity on
+type mentionTier struct {
+	MinScale int
+	// A Discord role ID, or "everyone"
+	Target string
+}
+
+// Parse "5 weak=123456789,6 weak=everyone" into tiers, strongest first.
+// Intensities are given as in MIN_SCALE.
+func parseMentionTiers(value string, scales map[int]string) []mentionTier {
+	var tiers []mentionTier
+	for _, entry := range parseList(value) {
+		scale, target, found := strings.Cut(entry, "=")
+		target = strings.TrimSpace(target)
+		if !found || target == "" || strings.TrimSpace(scale) == "" {
+			logWarn("config", "Invalid MENTION_TIERS entry %q, expected intensity=role ID or intensity=everyone", entry)
+			continue
+		}
+		// An unknown intensity is reported by parseMinScale
+		minScale := parseMinScale("MENTION_TIERS", scale, scales)
+		if minScale == 0 {
+			continue
+		}
+		tiers = append(tiers, mentionTier{MinScale: minScale, Target: target})
+	}
+	// Strongest first, so that the first tier met is the one used
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinScale > tiers[j].MinScale
+	})
+	return tiers
+}
+
+// Whether any mention rule is configured. DISCORD_MENTION_ENABLED=true alone
+// mentions every alert; the intensity and magnitude thresholds enable
+// mentions by themselves.
+func mentionsEnabled(env Env) bool {
+	return env.DiscordMentionEnabled || env.MentionMinScale > 0 || len(env.MentionTiers) > 0 || env.MentionMinMagnitude > 0
+}
+
+// The tier an earthquake message falls into. Tsunami warnings fall into the
+// strongest tier, since they are rare and urgent. ok is false when the
+// intensity is below every tier, which includes messages without one.
+func matchMentionTier(body MessageBody, tiers []mentionTier) (tier mentionTier, ok bool) {
+	if len(tiers) == 0 {
+		return mentionTier{}, true
+	}
+	if body.Tsunami {
+		return tiers[0], true
+	}
+	for _, t := range tiers {
+		if body.MaxScale >= t.MinScale {
+			return t, true
+		}
+	}
+	return mentionTier{}, false
+}
+
+// Mention text for a message: its tier's role, else MENTION_ROLE_ID, else
+// @everyone. Only Discord understands role mentions; other sinks get
+// @everyone, which they translate or drop.
+func mentionText(body MessageBody, discord bool) string {
+	env := currentEnv()
+	target := env.MentionRoleID
+	if tier, ok := matchMentionTier(body, env.MentionTiers); ok && tier.Target != "" {
+		target = tier.Target
+	}
+	if target == "" || strings.EqualFold(target, "everyone") || !discord {
+		return "@everyone"
+	}
+	return "<@&" + target + ">"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+//────────────────────────────
+// Prometheus Metrics (METRICS_PORT)
+//────────────────────────────
+
+var (
+	metricMessagesReceived   atomic.Int64
+	metricQuakesProcessed    atomic.Int64
+	metricWebhooksSent       atomic.Int64
+	metricWebhooksFailed     atomic.Int64
+	metricReconnectAttempts  atomic.Int64
+	metricConnected          atomic.Bool
+	metricLastMessageUnixSec atomic.Int64
+)
+
+// Count a message received from upstream
+func observeMessage() {
+	metricMessagesReceived.Add(1)
+	metricLastMessageUnixSec.Store(time.Now().Unix())
+	noteActivity()
+}
+
+func observeWebhook(ok bool) {
+	if ok {
+		metricWebhooksSent.Add(1)
+	} else {
+		metricWebhooksFailed.Add(1)
+	}
+}
+
+// Serve /metrics in the Prometheus text format on METRICS_PORT
+func registerMetrics() {
+	env := currentEnv()
+	if env.MetricsPort == "" {
+		return
+	}
+	handleHTTP(env.MetricsPort, "/metrics", handleMetrics)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	connected := 0
+	if metricConnected.Load() {
+		connected = 1
+	}
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"micro_messages_received_total", "counter", "Messages received from P2PQuake.", metricMessagesReceived.Load()},
+		{"micro_earthquakes_processed_total", "counter", "Earthquake reports handled.", metricQuakesProcessed.Load()},
+		{"micro_webhooks_sent_total", "counter", "Webhook requests that succeeded.", metricWebhooksSent.Load()},
+		{"micro_webhooks_failed_total", "counter", "Webhook requests that failed.", metricWebhooksFailed.Load()},
+		{"micro_reconnect_attempts_total", "counter", "WebSocket reconnection attempts.", metricReconnectAttempts.Load()},
+		{"micro_websocket_connected", "gauge", "Whether the WebSocket connection is open (1) or not (0).", int64(connected)},
+		{"micro_last_message_timestamp_seconds", "gauge", "Unix time of the last message received (0 before the first).", metricLastMessageUnixSec.Load()},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+)
+
+//────────────────────────────
+// Normalized Event Schema
+//────────────────────────────
+
+// NormalizedEvent is the stable representation of an event shared by the
+// message builders and the generic sink, independent of P2PQuake's wire format.
+// Optional values are omitted when unknown.
+type NormalizedEvent struct {
+	// "earthquake" or "tsunami"
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// Origin time (earthquakes) or issue time (tsunamis) in RFC 3339
+	Time       string               `json:"time"`
+	Magnitude  *float64             `json:"magnitude,omitempty"`
+	DepthKm    *float64             `json:"depthKm,omitempty"`
+	Epicenter  *NormalizedEpicenter `json:"epicenter,omitempty"`
+	MaxScale   int                  `json:"maxScale,omitempty"`
+	MaxLabel   string               `json:"maxIntensity,omitempty"`
+	PointCount int                  `json:"pointCount,omitempty"`
+	// Highest intensity per prefecture, strongest first
+	Prefectures []PrefectureIntensity `json:"prefectures,omitempty"`
+	// JMA tsunami status codes attached to an earthquake (e.g. "None", "Warning")
+	DomesticTsunami string `json:"domesticTsunami,omitempty"`
+	ForeignTsunami  string `json:"foreignTsunami,omitempty"`
+	// Tsunami forecast areas (tsunami events only)
+	TsunamiAreas []NormalizedTsunamiArea `json:"tsunamiAreas,omitempty"`
+	Cancelled    bool                    `json:"cancelled,omitempty"`
+	// Received from the sandbox feed
+	Sandbox bool `json:"sandbox,omitempty"`
+	// Training distribution (earthquakes only), see isDrill
+	Drill bool `json:"drill,omitempty"`
+	// Set by the generic sink, see idempotencyKey
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type NormalizedEpicenter struct {
+	// Name as reported (Japanese) and its translation
+	Name       string   `json:"name"`
+	Translated string   `json:"translated"`
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+}
+
+type PrefectureIntensity struct {
+	Name     string `json:"name"`
+	MaxScale int    `json:"maxScale"`
+	MaxLabel string `json:"maxIntensity"`
+}
+
+type NormalizedTsunamiArea struct {
+	Name      string `json:"name"`
+	Grade     string `json:"grade"`
+	Immediate bool   `json:"immediate,omitempty"`
+}
+
+// P2PQuake times carry no zone; they are JST
+var jst = time.FixedZone("JST", 9*60*60)
+
+// Zone event times are shown in (DISPLAY_TIMEZONE, JST by default)
+func displayLocation() *time.Location {
+	if loc := currentEnv().DisplayLocation; loc != nil {
+		return loc
+	}
+	return jst
+}
+
+// Parse a P2PQuake time ("2006/01/02 15:04:05") into the display zone
+func displayTime(value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", value, jst)
+	if err != nil {
+		return t, err
+	}
+	return t.In(displayLocation()), nil
+}
+
+func normalizeTime(value, layout string) string {
+	t, err := time.ParseInLocation(layout, value, jst)
+	if err != nil {
+		return value
+	}
+	return t.Format(time.RFC3339)
+}
+
+func normalizeQuake(eq JMAQuake, groups []PointGroup) NormalizedEvent {
+	ev := NormalizedEvent{
+		Type:            "earthquake",
+		ID:              eq.ID,
+		Time:            normalizeTime(eq.Earthquake.Time, "2006/01/02 15:04:05"),
+		MaxScale:        eq.Earthquake.MaxScale,
+		PointCount:      len(eq.Points),
+		DomesticTsunami: eq.Earthquake.DomesticTsunami,
+		ForeignTsunami:  eq.Earthquake.ForeignTsunami,
+		Drill:           isDrill(eq),
+	}
+	ev.MaxLabel, _ = parseScale(eq.Earthquake.MaxScale)
+	// P2PQuake uses -1 for an undetermined magnitude or depth, and -200 for coordinates
+	if h := eq.Earthquake.Hypocenter; h != nil {
+		if h.Magnitude > 0 {
+			magnitude := h.Magnitude
+			ev.Magnitude = &magnitude
+		}
+		if h.Name != "" && h.Depth >= 0 {
+			depth := h.Depth
+			ev.DepthKm = &depth
+		}
+		if h.Name != "" || hasCoordinates(h) {
+			ev.Epicenter = &NormalizedEpicenter{Name: h.Name, Translated: translate(h.Name)}
+			if hasCoordinates(h) {
+				lat, lon := h.Latitude, h.Longitude
+				ev.Epicenter.Latitude, ev.Epicenter.Longitude = &lat, &lon
+			}
+		}
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		for _, region := range groups[i].Regions {
+			ev.Prefectures = append(ev.Prefectures, PrefectureIntensity{Name: region, MaxScale: groups[i].ScaleInt, MaxLabel: groups[i].ScaleStr})
+		}
+	}
+	return ev
+}
+
+func normalizeTsunami(t JMATsunami) NormalizedEvent {
+	ev := NormalizedEvent{
+		Type:      "tsunami",
+		ID:        t.ID,
+		Time:      normalizeTime(t.Issue.Time, "2006/01/02 15:04:05"),
+		Cancelled: t.Cancelled,
+	}
+	for _, a := range t.Areas {
+		ev.TsunamiAreas = append(ev.TsunamiAreas, NormalizedTsunamiArea{Name: a.Name, Grade: a.Grade, Immediate: a.Immediate})
+	}
+	return ev
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+//────────────────────────────
+// Notifiers
+//────────────────────────────
+
+// A Notifier turns a rendered message into the JSON a chat service expects
+type Notifier interface {
+	// Payload builds the request body; content carries the mention text, if any
+	Payload(body MessageBody, content string) interface{}
+	// ValidURL reports whether the URL looks like one of the service's webhooks
+	ValidURL(u string) bool
+	// Discord-only features (ticker edits, forum threads, message links) are skipped otherwise
+	IsDiscord() bool
+}
+
+var notifiers = map[string]Notifier{
+	"discord": discordNotifier{},
+	"teams":   teamsNotifier{},
+	"slack":   slackNotifier{},
+}
+
+// The notifier selected by SINK_TYPE or WEBHOOK_FORMAT (Discord by default)
+func activeNotifier() Notifier {
+	return notifierFor(currentEnv().SinkType)
+}
+
+// The notifier of a destination type (Discord for unknown types)
+func notifierFor(kind string) Notifier {
+	if n, ok := notifiers[kind]; ok {
+		return n
+	}
+	return discordNotifier{}
+}
+
+// Unknown sink types fall back to Discord
+func parseSinkType(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "discord"
+	}
+	if _, ok := notifiers[value]; !ok {
+		logWarn("config", "Unknown SINK_TYPE/WEBHOOK_FORMAT %q, using discord", value)
+		return "discord"
+	}
+	return value
+}
+
+type discordNotifier struct{}
+
+func (discordNotifier) Payload(body MessageBody, content string) interface{} {
+	return WebhookPayload{
+		Content:    content,
+		Embeds:     []MessageBody{body},
+		ThreadName: body.ThreadName,
+	}
+}
+
+func (discordNotifier) ValidURL(u string) bool {
+	return isValidWebhookURL(u)
+}
+
+func (discordNotifier) IsDiscord() bool {
+	return true
+}
+
+// Office 365 connector card, see
+// https://learn.microsoft.com/en-us/outlook/actionable-messages/message-card-reference
+type TeamsMessageCard struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	ThemeColor string         `json:"themeColor,omitempty"`
+	Summary    string         `json:"summary"`
+	Title      string         `json:"title"`
+	Text       string         `json:"text,omitempty"`
+	Sections   []TeamsSection `json:"sections,omitempty"`
+}
+
+type TeamsSection struct {
+	ActivityTitle string `json:"activityTitle,omitempty"`
+	Text          string `json:"text"`
+}
+
+type teamsNotifier struct{}
+
+// Teams has no @everyone equivalent, so the mention content is dropped
+func (teamsNotifier) Payload(body MessageBody, _ string) interface{} {
+	card := TeamsMessageCard{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Summary: body.Title,
+		Title:   body.Title,
+		Text:    body.Description,
+	}
+	if body.Color != 0 {
+		card.ThemeColor = fmt.Sprintf("%06X", body.Color)
+	}
+	// One section per field, so every intensity group gets its own block
+	for _, field := range body.Fields {
+		card.Sections = append(card.Sections, TeamsSection{
+			ActivityTitle: field.Name,
+			Text:          field.Value,
+		})
+	}
+	if body.Footer != nil && body.Footer.Text != "" {
+		card.Sections = append(card.Sections, TeamsSection{Text: body.Footer.Text})
+	}
+	return card
+}
+
+// Incoming webhooks live on webhook.office.com, older connectors on outlook.office.com
+// and Workflows on logic.azure.com
+func (teamsNotifier) ValidURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	return host == "outlook.office.com" ||
+		strings.HasSuffix(host, ".webhook.office.com") ||
+		strings.HasSuffix(host, ".logic.azure.com")
+}
+
+func (teamsNotifier) IsDiscord() bool {
+	return false
+}
+
+// Slack incoming webhook payload: a fallback text plus one colored attachment
+// holding Block Kit blocks, see https://api.slack.com/messaging/webhooks
+type SlackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+type SlackAttachment struct {
+	Color  string       `json:"color,omitempty"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text,omitempty"`
+	Fields   []SlackText `json:"fields,omitempty"`
+	Elements []SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Slack allows at most 10 fields per section block
+const slackFieldsPerSection = 10
+
+type slackNotifier struct{}
+
+// Discord markdown is close enough to Slack mrkdwn apart from bold
+func slackMarkdown(text string) string {
+	return strings.ReplaceAll(text, "**", "*")
+}
+
+func (slackNotifier) Payload(body MessageBody, content string) interface{} {
+	text := body.Title
+	if content != "" {
+		text = strings.ReplaceAll(content, "@everyone", "<!channel>") + " " + text
+	}
+	blocks := []SlackBlock{{Type: "header", Text: &SlackText{Type: "plain_text", Text: body.Title}}}
+	if body.Description != "" {
+		blocks = append(blocks, SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: slackMarkdown(body.Description)}})
+	}
+	var fields []SlackText
+	for _, field := range body.Fields {
+		fields = append(fields, SlackText{Type: "mrkdwn", Text: "*" + field.Name + "*\n" + slackMarkdown(field.Value)})
+	}
+	for len(fields) > 0 {
+		n := min(len(fields), slackFieldsPerSection)
+		blocks = append(blocks, SlackBlock{Type: "section", Fields: fields[:n]})
+		fields = fields[n:]
+	}
+	if body.Footer != nil && body.Footer.Text != "" {
+		blocks = append(blocks, SlackBlock{Type: "context", Elements: []SlackText{{Type: "mrkdwn", Text: body.Footer.Text}}})
+	}
+	attachment := SlackAttachment{Blocks: blocks}
+	if body.Color != 0 {
+		attachment.Color = fmt.Sprintf("#%06X", body.Color)
+	}
+	return SlackPayload{Text: text, Attachments: []SlackAttachment{attachment}}
+}
+
+func (slackNotifier) ValidURL(u string) bool {
+	return strings.HasPrefix(u, "https://hooks.slack.com/services/") ||
+		strings.HasPrefix(u, "https://hooks.slack.com/workflows/")
+}
+
+func (slackNotifier) IsDiscord() bool {
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Send Ordering (per-webhook serialization, stale drop)
+//────────────────────────────
+
+// Position of a message in the send order, assigned when it enters sendMessage
+type SendTag struct {
+	Seq    uint64
+	Queued time.Time
+}
+
+var (
+	orderMu       sync.Mutex
+	sendSeq       uint64
+	lastPostedSeq = make(map[string]uint64)
+	webhookLocks  = make(map[string]*sync.Mutex)
+)
+
+func nextSendTag() SendTag {
+	orderMu.Lock()
+	defer orderMu.Unlock()
+	sendSeq++
+	return SendTag{Seq: sendSeq, Queued: time.Now()}
+}
+
+// Sends to one webhook are serialized, so a slow or retried send holds back later ones
+func lockWebhook(url string) func() {
+	orderMu.Lock()
+	lock, ok := webhookLocks[url]
+	if !ok {
+		lock = &sync.Mutex{}
+		webhookLocks[url] = lock
+	}
+	orderMu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// A message is stale when a newer one was already posted to the webhook and it
+// has waited longer than SEND_MAX_AGE; posting it would break newest-is-last
+func isStale(url string, tag SendTag, maxAge time.Duration) bool {
+	orderMu.Lock()
+	defer orderMu.Unlock()
+	return lastPostedSeq[url] > tag.Seq && maxAge > 0 && time.Since(tag.Queued) > maxAge
+}
+
+func markPosted(url string, tag SendTag) {
+	orderMu.Lock()
+	defer orderMu.Unlock()
+	if tag.Seq > lastPostedSeq[url] {
+		lastPostedSeq[url] = tag.Seq
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//────────────────────────────
+// Points of Interest (POINTS_OF_INTEREST)
+//────────────────────────────
+
+// A named location (power plant, dam, ...) to report the epicenter distance to
+type PointOfInterest struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// Annotation radius; 0 uses POI_RADIUS_KM
+	RadiusKm float64
+}
+
+// Parse "Name:lat:lon[:radiusKm]" entries, e.g. "Tokai No.2:36.466:140.606:80"
+func parsePointsOfInterest(value string) []PointOfInterest {
+	var points []PointOfInterest
+	for _, entry := range parseList(value) {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			logWarn("config", "Invalid POINTS_OF_INTEREST entry %q, expected Name:lat:lon[:radiusKm]", entry)
+			continue
+		}
+		p := PointOfInterest{Name: strings.TrimSpace(parts[0])}
+		var errs [3]error
+		p.Latitude, errs[0] = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		p.Longitude, errs[1] = strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if len(parts) == 4 {
+			p.RadiusKm, errs[2] = strconv.ParseFloat(strings.TrimSpace(parts[3]), 64)
+		}
+		if p.Name == "" || errs[0] != nil || errs[1] != nil || errs[2] != nil {
+			logWarn("config", "Invalid POINTS_OF_INTEREST entry %q, expected Name:lat:lon[:radiusKm]", entry)
+			continue
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// One line per configured point within its radius of the epicenter, nearest first
+func pointOfInterestNotes(ev NormalizedEvent) []string {
+	env := currentEnv()
+	if len(env.PointsOfInterest) == 0 || ev.Epicenter == nil || ev.Epicenter.Latitude == nil {
+		return nil
+	}
+	type near struct {
+		Name string
+		Km   float64
+	}
+	var nearby []near
+	for _, p := range env.PointsOfInterest {
+		radius := p.RadiusKm
+		if radius <= 0 {
+			radius = env.POIRadiusKm
+		}
+		km := haversineKm(*ev.Epicenter.Latitude, *ev.Epicenter.Longitude, p.Latitude, p.Longitude)
+		if km <= radius {
+			nearby = append(nearby, near{p.Name, km})
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool {
+		return nearby[i].Km < nearby[j].Km
+	})
+	var notes []string
+	for _, n := range nearby {
+		notes = append(notes, fmt.Sprintf(tr("poi_distance"), n.Km, n.Name))
+	}
+	return notes
+}
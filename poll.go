@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+//────────────────────────────
+// HTTP Polling Transport (for networks without WebSocket)
+//────────────────────────────
+
+func historyURL(isDev bool) string {
+	if isDev {
+		return "https://api-v2-sandbox.p2pquake.net/v2/history"
+	}
+	return "https://api.p2pquake.net/v2/history"
+}
+
+// Fetch the most recent earthquake and tsunami messages, newest first
+func fetchHistory(isDev bool, limit int) ([]json.RawMessage, error) {
+	release := acquireDial()
+	defer release()
+	resp, err := apiClient.Get(fmt.Sprintf("%s?codes=551&codes=552&limit=%d", historyURL(isDev), limit))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("history request failed (HTTP %d)", resp.StatusCode)
+	}
+	var items []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("decoding history: %w", err)
+	}
+	return items, nil
+}
+
+// Poll the REST history every POLL_INTERVAL and feed new events into the
+// same pipeline as WebSocket messages. Events present at startup are only
+// posted when replayMissed picks them up (STATE_FILE gap or BACKFILL).
+func runPolling(ctx context.Context, isDev bool) {
+	env := currentEnv()
+	logInfo("startup", "Polling %s every %v", historyURL(isDev), env.PollInterval)
+	replayMissed(isDev)
+	var seen map[string]bool
+	for {
+		items, err := fetchHistory(isDev, 20)
+		// For health and metrics, the transport is up while polls succeed
+		metricConnected.Store(err == nil)
+		if err != nil {
+			logError("poll", "Polling error: %v", err)
+		} else {
+			noteActivity()
+			current := make(map[string]bool, len(items))
+			// Oldest first, so that alerts are posted in chronological order
+			for i := len(items) - 1; i >= 0; i-- {
+				var basic BasicData
+				err := json.Unmarshal(items[i], &basic)
+				basic.fillID()
+				if err != nil || basic.ID == "" {
+					continue
+				}
+				current[basic.ID] = true
+				if seen != nil && !seen[basic.ID] {
+					dispatchMessage(items[i], isDev)
+				}
+			}
+			seen = current
+		}
+		if !sleepContext(ctx, env.PollInterval) {
+			return
+		}
+	}
+}
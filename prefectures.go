@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+)
+
+//────────────────────────────
+// Prefecture Names (TARGET_PREFECTURES, NO_MENTION_PREFECTURES)
+//────────────────────────────
+
+// Resolve a configured prefecture to the name quakes are matched against:
+// the translated name that translate gives its Japanese name. English names
+// match case-insensitively, and Japanese names with or without their suffix
+// ("東京都", "東京").
+func canonicalPrefecture(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", false
+	}
+	for jp, en := range translateMap {
+		canonical := translate(jp)
+		short := jp
+		for _, suffix := range []string{"都", "府", "県"} {
+			short = strings.TrimSuffix(short, suffix)
+		}
+		if name == jp || name == short || strings.EqualFold(name, en) || strings.EqualFold(name, canonical) {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// Normalize a list of prefectures, warning about each entry that names none.
+// When no entry is recognized, the list is kept as given: it then matches no
+// quake, as before, and main refuses to start with it.
+func normalizePrefectures(key string, names []string) (normalized, unknown []string) {
+	for _, name := range names {
+		canonical, ok := canonicalPrefecture(name)
+		if !ok {
+			logWarn("config", "Unknown prefecture %q in %s, it matches no quake", name, key)
+			unknown = append(unknown, name)
+			continue
+		}
+		if !containsString(normalized, canonical) {
+			normalized = append(normalized, canonical)
+		}
+	}
+	if len(normalized) == 0 && len(unknown) > 0 {
+		return unknown, unknown
+	}
+	return normalized, unknown
+}
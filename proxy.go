@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//────────────────────────────
+// Outbound Proxy (PROXY_URL, HTTP(S)_PROXY, ALL_PROXY)
+//────────────────────────────
+
+// Dialer for the P2PQuake WebSocket. wss:// goes through HTTP proxies by
+// CONNECT tunneling; socks5:// proxies are supported as well.
+var wsDialer = &websocket.Dialer{
+	Proxy:            proxyForRequest,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// Pick the proxy for a request: PROXY_URL applies to everything; otherwise
+// HTTP_PROXY/HTTPS_PROXY (with NO_PROXY) are used, and ALL_PROXY covers the
+// hosts they leave out. PROXY_URL is read on every request so that reloads
+// apply to it, but net/http reads HTTP_PROXY, HTTPS_PROXY and NO_PROXY only
+// once: changing those takes a restart.
+func proxyForRequest(req *http.Request) (*url.URL, error) {
+	if explicit, err := parseProxyURL(configValue("PROXY_URL")); explicit != nil || err != nil {
+		return explicit, err
+	}
+	if proxy, err := http.ProxyFromEnvironment(req); proxy != nil || err != nil {
+		return proxy, err
+	}
+	all := os.Getenv("ALL_PROXY")
+	if all == "" {
+		all = os.Getenv("all_proxy")
+	}
+	if all == "" || bypassProxy(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return parseProxyURL(all)
+}
+
+// Parse a proxy address. A bare "host:port" is taken as an HTTP proxy.
+func parseProxyURL(value string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address: %v", err)
+	}
+	// The WebSocket dialer only speaks these two
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http or socks5)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy address %q has no host", value)
+	}
+	return u, nil
+}
+
+// Whether NO_PROXY exempts a host from ALL_PROXY. Entries match the host
+// itself and its subdomains; "*" matches everything.
+func bypassProxy(host string) bool {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	host = strings.ToLower(host)
+	for _, entry := range parseList(noProxy) {
+		entry = strings.ToLower(strings.TrimPrefix(entry, "."))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//────────────────────────────
+// Rate Limit Handling (429 Too Many Requests)
+//────────────────────────────
+
+// Longer waits are not worth holding the alert for; the send fails instead
+const maxRetryAfter = 60 * time.Second
+
+// How long a 429 response asks us to wait. Discord puts it in the JSON body
+// (retry_after, in seconds, fractional) and the Retry-After header; the body
+// is preferred since it is more precise. The body is consumed.
+func retryAfter(resp *http.Response) time.Duration {
+	var limited struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &limited) == nil && limited.RetryAfter > 0 {
+		return time.Duration(limited.RetryAfter * float64(time.Second))
+	}
+	if secs, err := strconv.ParseFloat(strings.TrimSpace(resp.Header.Get("Retry-After")), 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	return time.Second
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Event Deduplication & Reconnect Gap Replay
+//────────────────────────────
+
+// How long handled event IDs are remembered for deduplication
+const seenRetention = 24 * time.Hour
+
+var (
+	seenMu        sync.Mutex
+	seenIDs       = make(map[string]time.Time)
+	lastEventTime time.Time
+)
+
+// Record an event ID and report whether it had not been seen before.
+// New IDs are saved to STATE_FILE shortly after, see scheduleStateSave.
+func markSeen(id string) bool {
+	if !rememberID(id) {
+		return false
+	}
+	scheduleStateSave()
+	return true
+}
+
+func rememberID(id string) bool {
+	seenMu.Lock()
+	defer seenMu.Unlock()
+	now := time.Now()
+	for k, t := range seenIDs {
+		if now.Sub(t) > seenRetention {
+			delete(seenIDs, k)
+		}
+	}
+	if _, ok := seenIDs[id]; ok {
+		return false
+	}
+	seenIDs[id] = now
+	return true
+}
+
+// Number of event IDs currently remembered for deduplication
+func seenCount() int {
+	seenMu.Lock()
+	defer seenMu.Unlock()
+	return len(seenIDs)
+}
+
+// How a report got past deduplication. Every report handled has a new event
+// ID; with FIRST_REPORT_ONLY it also passed the per-quake check, which
+// corrections do not skip.
+func quakeDedup(eq JMAQuake) string {
+	env := currentEnv()
+	switch {
+	case env.FirstReportOnly && containsString(env.Filters, "first-report"):
+		return "first report of this quake"
+	case isCorrection(eq):
+		return "correction, repeat checks skipped"
+	}
+	return "new event ID"
+}
+
+// Parse the issue time of a message, given in JST
+func parseIssueTime(timeStr string) (time.Time, error) {
+	return time.ParseInLocation("2006/01/02 15:04:05.000", timeStr, jst)
+}
+
+// Remember the issue time of the newest message received
+func recordEventTime(timeStr string) {
+	t, err := parseIssueTime(timeStr)
+	if err != nil {
+		return
+	}
+	seenMu.Lock()
+	defer seenMu.Unlock()
+	if t.After(lastEventTime) {
+		lastEventTime = t
+	}
+}
+
+// After a reconnect, fetch the events issued since the last one received and
+// queue them for the workers like live messages, so that they keep the
+// per-event order and are waited for on shutdown; the ID deduplication drops
+// those already handled.
+//
+// With BACKFILL=true, the events of the last BACKFILL_WINDOW are replayed at
+// startup as well, and no gap reaches further back than that. Only STATE_FILE
+// tells which of them were posted before the restart; without it they are
+// all posted again.
+func replayMissed(isDev bool) {
+	env := currentEnv()
+	seenMu.Lock()
+	since := lastEventTime
+	seenMu.Unlock()
+	limit := 50
+	if env.Backfill {
+		if cutoff := time.Now().Add(-env.BackfillWindow); since.Before(cutoff) {
+			since = cutoff
+		}
+		limit = 100
+	}
+	if since.IsZero() {
+		return
+	}
+	items, err := fetchHistory(isDev, limit)
+	if err != nil {
+		logError("replay", "Error fetching history for replay: %v", err)
+		return
+	}
+	replayed := 0
+	for i := len(items) - 1; i >= 0; i-- {
+		var basic BasicData
+		if err := json.Unmarshal(items[i], &basic); err != nil {
+			continue
+		}
+		t, err := parseIssueTime(basic.Time)
+		if err != nil || !t.After(since) {
+			continue
+		}
+		dispatchMessage(items[i], isDev)
+		replayed++
+	}
+	if replayed > 0 && env.EnableLogger {
+		withFields(logFields{"count": replayed}).info("replay", "Replayed %d event(s) missed while disconnected", replayed)
+	}
+}
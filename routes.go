@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+)
+
+//────────────────────────────
+// Per-prefecture Webhook Routing (WEBHOOK_ROUTES)
+//────────────────────────────
+
+// A destination that only receives quakes affecting its prefectures.
+// A route without prefectures is a catch-all.
+type WebhookRoute struct {
+	Prefectures []string
+	URL         string
+}
+
+// Parse "Tokyo|Kanagawa=https://...,Osaka=https://...,*=https://...".
+// Prefectures are normalized as in TARGET_PREFECTURES.
+func parseRoutes(value string) []WebhookRoute {
+	var routes []WebhookRoute
+	for _, entry := range parseList(value) {
+		prefs, url, found := strings.Cut(entry, "=")
+		url = strings.TrimSpace(url)
+		if !found || url == "" {
+			logWarn("config", "Invalid WEBHOOK_ROUTES entry %q, expected Prefecture|Prefecture=URL", entry)
+			continue
+		}
+		route := WebhookRoute{URL: url}
+		route.Prefectures = parsePrefectureSet("WEBHOOK_ROUTES", prefs)
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Normalize a "Tokyo|Kanagawa" list of a route or destination. "*" (or
+// nothing) stands for every prefecture and yields nil.
+func parsePrefectureSet(key, value string) []string {
+	var names []string
+	for _, pref := range strings.Split(value, "|") {
+		pref = strings.TrimSpace(pref)
+		if pref == "*" {
+			return nil
+		}
+		if pref != "" {
+			names = append(names, pref)
+		}
+	}
+	prefs, _ := normalizePrefectures(key, names)
+	return prefs
+}
+
+// Whether a route or destination limited to prefs receives a message about
+// affected. Messages naming no prefecture (status notices, tsunami lifts) go
+// to every route, so that no channel misses them.
+func prefecturesMatch(prefs, affected []string) bool {
+	if len(prefs) == 0 || len(affected) == 0 {
+		return true
+	}
+	for _, pref := range affected {
+		if containsString(prefs, pref) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//────────────────────────────
+// Secret References (SECRET_SOURCE)
+//────────────────────────────
+
+// Values of the form secret://<reference> are fetched from the store named by
+// SECRET_SOURCE instead of being used literally:
+//
+//	file   secret:///run/secrets/webhook          (file contents)
+//	vault  secret://secret/data/micro#webhook     (VAULT_ADDR, VAULT_TOKEN; KV v1 or v2)
+//	gcp    secret://projects/p/secrets/webhook    (token from the metadata server)
+//	aws    secret://micro/webhook#url             (AWS_REGION and AWS_* credentials)
+//
+// "#key" picks one entry from a JSON secret.
+const secretPrefix = "secret://"
+
+// Read a variable that may hold a secret reference. A failed resolution
+// yields an empty value along with the error.
+func getEnvSecret(key string) (string, error) {
+	value := configValue(key)
+	ref, ok := strings.CutPrefix(strings.TrimSpace(value), secretPrefix)
+	if !ok {
+		return value, nil
+	}
+	resolved, err := resolveSecret(os.Getenv("SECRET_SOURCE"), ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resolved), nil
+}
+
+func resolveSecret(source, ref string) (string, error) {
+	ref, key, _ := strings.Cut(ref, "#")
+	var raw string
+	var err error
+	switch source {
+	case "file":
+		var data []byte
+		data, err = os.ReadFile(ref)
+		raw = string(data)
+	case "vault":
+		if key == "" {
+			key = "value"
+		}
+		return vaultSecret(ref, key)
+	case "gcp":
+		raw, err = gcpSecret(ref)
+	case "aws":
+		raw, err = awsSecret(ref)
+	case "":
+		return "", fmt.Errorf("secret reference used but SECRET_SOURCE is not set")
+	default:
+		return "", fmt.Errorf("unknown SECRET_SOURCE %q", source)
+	}
+	if err != nil {
+		return "", err
+	}
+	return pickSecretKey(raw, key)
+}
+
+// Select one entry of a JSON object secret ("" returns the whole secret)
+func pickSecretKey(raw, key string) (string, error) {
+	if key == "" {
+		return raw, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select %q", key)
+	}
+	v, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// Perform a request and return the body, treating HTTP errors as failures
+func fetchSecret(req *http.Request) ([]byte, error) {
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("secret request failed (HTTP %d)", resp.StatusCode)
+	}
+	return data, nil
+}
+
+func vaultSecret(path, key string) (string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest("GET", addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	data, err := fetchSecret(req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	// KV v2 nests the values one level deeper
+	values := resp.Data
+	if nested, ok := resp.Data["data"]; ok && resp.Data["metadata"] != nil {
+		values = nil
+		if err := json.Unmarshal(nested, &values); err != nil {
+			return "", fmt.Errorf("decoding vault response: %w", err)
+		}
+	}
+	raw, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret has no key %q", key)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return string(raw), nil
+	}
+	return s, nil
+}
+
+func gcpSecret(name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	// Access token of the instance service account
+	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	data, err := fetchSecret(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching GCP access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return "", fmt.Errorf("decoding GCP access token: %w", err)
+	}
+
+	req, err = http.NewRequest("GET", "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	data, err = fetchSecret(req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("decoding GCP secret: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding GCP secret: %w", err)
+	}
+	return string(value), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorization header of a Signature Version 4 signed "POST /" request.
+// headers are the signed headers in lowercase, sorted by name, and must
+// include host and x-amz-date, which also dates the signature.
+func sigV4Authorization(headers [][2]string, body []byte, region, service, accessKey, secretKey string) string {
+	var amzDate string
+	var canonicalHeaders strings.Builder
+	var names []string
+	for _, h := range headers {
+		if h[0] == "x-amz-date" {
+			amzDate = h[1]
+		}
+		canonicalHeaders.WriteString(h[0] + ":" + h[1] + "\n")
+		names = append(names, h[0])
+	}
+	date, _, _ := strings.Cut(amzDate, "T")
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := "POST\n/\n\n" + canonicalHeaders.String() + "\n" + signedHeaders + "\n" + sha256Hex(body)
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature)
+}
+
+// GetSecretValue on AWS Secrets Manager, signed with Signature Version 4
+func awsSecret(id string) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if region == "" || accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	host := "secretsmanager." + region + ".amazonaws.com"
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	headers := [][2]string{
+		{"content-type", "application/x-amz-json-1.1"},
+		{"host", host},
+		{"x-amz-date", amzDate},
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers = append(headers, [2]string{"x-amz-security-token", token})
+	}
+	headers = append(headers, [2]string{"x-amz-target", "secretsmanager.GetSecretValue"})
+
+	req, err := http.NewRequest("POST", "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for _, h := range headers {
+		if h[0] != "host" {
+			req.Header.Set(h[0], h[1])
+		}
+	}
+	req.Header.Set("Authorization", sigV4Authorization(headers, body, region, "secretsmanager", accessKey, secretKey))
+	data, err := fetchSecret(req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("decoding AWS secret: %w", err)
+	}
+	return resp.SecretString, nil
+}
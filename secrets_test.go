@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// post-vanilla from the AWS Signature Version 4 test suite
+func TestSigV4Authorization(t *testing.T) {
+	headers := [][2]string{
+		{"host", "example.amazonaws.com"},
+		{"x-amz-date", "20150830T123600Z"},
+	}
+	got := sigV4Authorization(headers, nil, "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"
+	if got != want {
+		t.Errorf("sigV4Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	object := filepath.Join(dir, "object.json")
+	os.WriteFile(plain, []byte("https://discord.com/api/webhooks/1/a\n"), 0o600)
+	os.WriteFile(object, []byte(`{"webhook": "https://discord.com/api/webhooks/2/b", "port": 8080}`), 0o600)
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vtoken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/micro": // KV v2
+			w.Write([]byte(`{"data": {"data": {"value": "v2-secret"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/micro": // KV v1
+			w.Write([]byte(`{"data": {"webhook": "v1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "vtoken")
+
+	tests := []struct {
+		name    string
+		source  string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"file", "file", plain, "https://discord.com/api/webhooks/1/a\n", false},
+		{"file key", "file", object + "#webhook", "https://discord.com/api/webhooks/2/b", false},
+		{"file non-string key", "file", object + "#port", "8080", false},
+		{"file missing key", "file", object + "#token", "", true},
+		{"file key of plain secret", "file", plain + "#webhook", "", true},
+		{"missing file", "file", filepath.Join(dir, "none"), "", true},
+		{"vault kv v2", "vault", "secret/data/micro", "v2-secret", false},
+		{"vault kv v1 key", "vault", "kv/micro#webhook", "v1-secret", false},
+		{"vault missing", "vault", "kv/other", "", true},
+		{"source unset", "", plain, "", true},
+		{"unknown source", "keychain", plain, "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSecret(tt.source, tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: resolveSecret(%q, %q) = %q, %v; want %q (error %v)", tt.name, tt.source, tt.ref, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetEnvSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook")
+	os.WriteFile(path, []byte(" https://discord.com/api/webhooks/1/a \n"), 0o600)
+	t.Setenv("SECRET_SOURCE", "file")
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"plain value", "https://discord.com/api/webhooks/9/z", "https://discord.com/api/webhooks/9/z", false},
+		{"reference, trimmed", "secret://" + path, "https://discord.com/api/webhooks/1/a", false},
+		{"failed reference", "secret://" + path + ".missing", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_SECRET", tt.value)
+		got, err := getEnvSecret("TEST_SECRET")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: getEnvSecret = %q, %v; want %q (error %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
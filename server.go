@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// HTTP Server (control endpoints)
+//────────────────────────────
+
+var (
+	httpMu      sync.Mutex
+	httpMuxes   = make(map[string]*http.ServeMux)
+	httpServers []*http.Server
+)
+
+// Register a handler on the server listening on port; features configured
+// with the same port share one server
+func handleHTTP(port, pattern string, handler http.HandlerFunc) {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	mux, ok := httpMuxes[port]
+	if !ok {
+		mux = http.NewServeMux()
+		httpMuxes[port] = mux
+	}
+	mux.HandleFunc(pattern, handler)
+}
+
+// Start one server per registered port in the background
+func startHTTPServers() {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	for port, mux := range httpMuxes {
+		server := &http.Server{
+			Addr:              ":" + port,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		httpServers = append(httpServers, server)
+		go func(port string) {
+			logInfo("startup", "HTTP server listening on port %s", port)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logError("http", "HTTP server error: %v", err)
+			}
+		}(port)
+	}
+}
+
+// Stop accepting requests and wait for the running ones until ctx is done
+func stopHTTPServers(ctx context.Context) {
+	httpMu.Lock()
+	servers := httpServers
+	httpServers = nil
+	httpMu.Unlock()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logError("shutdown", "Error stopping HTTP server: %v", err)
+		}
+	}
+}
+
+// Check the "Authorization: Bearer <CONTROL_TOKEN>" header
+func authorized(r *http.Request) bool {
+	env := currentEnv()
+	if env.ControlToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(env.ControlToken)) == 1
+}
+
+// Register the control endpoints on CONTROL_PORT (disabled without a token)
+func registerControlRoutes(isDev bool) {
+	env := currentEnv()
+	if env.ControlPort == "" {
+		return
+	}
+	if env.ControlToken == "" {
+		logWarn("config", "CONTROL_PORT is set but CONTROL_TOKEN is not, control endpoints are disabled")
+		return
+	}
+	handleHTTP(env.ControlPort, "/test", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := sendTestAlert(isDev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintln(w, "test alert sent")
+	})
+	handleHTTP(env.ControlPort, "/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statsSnapshot())
+	})
+	// POST /maintenance?enabled=true|false switches maintenance mode; GET reports it
+	handleHTTP(env.ControlPort, "/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			setMaintenance(enabled)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, "maintenance:", currentEnv().Maintenance)
+	})
+}
+
+// Build a synthetic quake affecting the target prefectures (or Tokyo)
+func sampleQuake() JMAQuake {
+	env := currentEnv()
+	var points []Point
+	for jp := range translateMap {
+		if containsString(env.TargetPrefectures, translate(jp)) {
+			points = append(points, Point{Pref: jp, Addr: jp, Scale: 30})
+		}
+	}
+	if len(points) == 0 {
+		points = append(points, Point{Pref: "東京都", Addr: "東京千代田区", Scale: 30})
+	}
+	eq := JMAQuake{
+		BasicData: BasicData{ID: fmt.Sprintf("test-%d", time.Now().Unix()), Code: 551, Time: time.Now().Format("2006/01/02 15:04:05.000")},
+		Issue:     Issue{Type: "DetailScale"},
+		Points:    points,
+	}
+	eq.Earthquake.Time = time.Now().Format("2006/01/02 15:04:05")
+	eq.Earthquake.MaxScale = 30
+	eq.Earthquake.Hypocenter = &Hypocenter{Name: "Test Epicenter", Depth: 10, Magnitude: 4.0}
+	return eq
+}
+
+// Send a synthetic alert through the message pipeline
+func sendTestAlert(isDev bool) error {
+	eq := sampleQuake()
+	scale, _ := parseScale(eq.Earthquake.MaxScale)
+	body := createEarthquakeMessage(eq, scale, parsePoints(eq.Points), isDev)
+	body.EventID = eq.ID
+	body.MaxScale = eq.Earthquake.MaxScale
+	body.Magnitude = eq.Earthquake.Hypocenter.Magnitude
+	body.Description = tr("test_alert") + body.Description
+	return sendMessage(body)
+}
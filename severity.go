@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//────────────────────────────
+// Severity Titles (TITLE_SEVERITY)
+//────────────────────────────
+
+// Title used from a minimum scale code upwards
+type severityTitle struct {
+	MinScale int
+	Title    string
+}
+
+// Default thresholds; the titles are translation keys
+var defaultSeverityTitles = []severityTitle{
+	{55, "title_major"},
+	{45, "title_strong"},
+	{30, "title_moderate"},
+	{0, "title_minor"},
+}
+
+// Parse TITLE_SEVERITY_MAP ("55:Major Earthquake,45:Strong Earthquake,...")
+// into thresholds sorted from the highest; nil when empty
+func parseSeverityTitles(value string) []severityTitle {
+	var titles []severityTitle
+	for _, entry := range parseList(value) {
+		code, title, found := strings.Cut(entry, ":")
+		minScale, err := strconv.Atoi(strings.TrimSpace(code))
+		if !found || err != nil || strings.TrimSpace(title) == "" {
+			logWarn("config", "Invalid TITLE_SEVERITY_MAP entry %q, expected scale:title", entry)
+			continue
+		}
+		titles = append(titles, severityTitle{MinScale: minScale, Title: strings.TrimSpace(title)})
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		return titles[i].MinScale > titles[j].MinScale
+	})
+	return titles
+}
+
+// Embed title for an earthquake; with TITLE_SEVERITY it names how strong the
+// shaking was, so that the notification preview alone conveys urgency
+func earthquakeTitle(maxScale int) string {
+	env := currentEnv()
+	if !env.TitleSeverity {
+		return tr("earthquake_title")
+	}
+	if len(env.TitleSeverityMap) > 0 {
+		for _, s := range env.TitleSeverityMap {
+			if maxScale >= s.MinScale {
+				return s.Title
+			}
+		}
+		return tr("earthquake_title")
+	}
+	for _, s := range defaultSeverityTitles {
+		if maxScale >= s.MinScale {
+			return tr(s.Title)
+		}
+	}
+	return tr("earthquake_title")
+}
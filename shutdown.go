@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+//────────────────────────────
+// Graceful Shutdown (SIGINT/SIGTERM)
+//────────────────────────────
+
+// Sleep for d, returning false early when ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Wait for in-flight messages, then post what is still pending, at most
+// SHUTDOWN_TIMEOUT in all
+func shutdown() {
+	env := currentEnv()
+	logInfo("shutdown", "Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), env.ShutdownTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		// Queued reports may still join a pending quake, so the aggregator is
+		// flushed only once the queues are drained. Held-back quakes are
+		// posted now rather than lost.
+		if quakeAggregator != nil {
+			quakeAggregator.FlushAll()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logWarn("shutdown", "Timed out waiting for in-flight messages")
+	}
+	stopHTTPServers(ctx)
+	saveState()
+	logInfo("shutdown", "Shutdown complete.")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Persistent State (STATE_FILE)
+//────────────────────────────
+
+// What survives a restart: the handled event IDs, so that nothing is posted
+// twice, and when the last event and message arrived
+type persistedState struct {
+	SeenIDs       map[string]time.Time `json:"seenIds"`
+	LastEventTime time.Time            `json:"lastEventTime"`
+	LastMessage   time.Time            `json:"lastMessage"`
+}
+
+var stateMu sync.Mutex
+
+// New event IDs are written to STATE_FILE together, at most this long after
+// the first of them, rather than rewriting the file for each one. Shutdown
+// saves whatever is still pending.
+var stateSaveDelay = time.Second
+
+var (
+	stateSaveMu      sync.Mutex
+	stateSavePending bool
+)
+
+// Save the state after stateSaveDelay, unless a save is already scheduled
+func scheduleStateSave() {
+	if currentEnv().StateFile == "" {
+		return
+	}
+	stateSaveMu.Lock()
+	defer stateSaveMu.Unlock()
+	if stateSavePending {
+		return
+	}
+	stateSavePending = true
+	time.AfterFunc(stateSaveDelay, func() {
+		// Cleared first, so that IDs added during the save schedule another
+		stateSaveMu.Lock()
+		stateSavePending = false
+		stateSaveMu.Unlock()
+		saveState()
+	})
+}
+
+// Restore the state saved in STATE_FILE. A missing or unreadable file is
+// treated as empty.
+func loadState() {
+	path := currentEnv().StateFile
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var state persistedState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		logWarn("state", "Ignoring unreadable state file %s: %v", path, err)
+		return
+	}
+	now := time.Now()
+	seenMu.Lock()
+	for id, t := range state.SeenIDs {
+		if now.Sub(t) <= seenRetention {
+			seenIDs[id] = t
+		}
+	}
+	// Older gaps are not replayed, see replayMissed
+	if now.Sub(state.LastEventTime) <= seenRetention {
+		lastEventTime = state.LastEventTime
+	}
+	count := len(seenIDs)
+	seenMu.Unlock()
+	if !state.LastMessage.IsZero() {
+		metricLastMessageUnixSec.Store(state.LastMessage.Unix())
+		logInfo("state", "Restored %d handled event(s); last message received %v ago", count, now.Sub(state.LastMessage).Round(time.Second))
+	}
+}
+
+// Write the current state to STATE_FILE, replacing it atomically
+func saveState() {
+	path := currentEnv().StateFile
+	if path == "" {
+		return
+	}
+	var state persistedState
+	seenMu.Lock()
+	state.SeenIDs = make(map[string]time.Time, len(seenIDs))
+	for id, t := range seenIDs {
+		state.SeenIDs[id] = t
+	}
+	state.LastEventTime = lastEventTime
+	seenMu.Unlock()
+	if sec := metricLastMessageUnixSec.Load(); sec > 0 {
+		state.LastMessage = time.Unix(sec, 0)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		logError("state", "Error encoding state: %v", err)
+		return
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		logError("state", "Error writing state file: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logError("state", "Error writing state file: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Per-webhook Delivery Stats (/stats)
+//────────────────────────────
+
+type WebhookStats struct {
+	// Masked URL, see maskWebhookURL
+	Webhook     string `json:"webhook"`
+	Successes   int    `json:"successes"`
+	Failures    int    `json:"failures"`
+	LastStatus  int    `json:"lastStatus,omitempty"`
+	LastSuccess string `json:"lastSuccess,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+	// When LastError happened, in RFC 3339
+	LastErrorTime string `json:"lastErrorTime,omitempty"`
+}
+
+var (
+	statsMu      sync.Mutex
+	webhookStats = make(map[string]*WebhookStats)
+)
+
+// Count the outcome of one send. failure describes what went wrong when the
+// request did not complete; otherwise the status code is reported.
+func recordWebhookResult(urlStr string, status int, ok bool, failure string) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := webhookStats[urlStr]
+	if s == nil {
+		s = &WebhookStats{Webhook: maskWebhookURL(urlStr)}
+		webhookStats[urlStr] = s
+	}
+	now := time.Now().Format(time.RFC3339)
+	if status != 0 {
+		s.LastStatus = status
+	}
+	if ok {
+		s.Successes++
+		s.LastSuccess = now
+		return
+	}
+	s.Failures++
+	s.LastErrorTime = now
+	s.LastError = failure
+	if failure == "" {
+		s.LastError = fmt.Sprintf("HTTP %d", status)
+	}
+}
+
+// Copy of the stats of every webhook used so far, ordered by masked URL
+func statsSnapshot() []WebhookStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	list := make([]WebhookStats, 0, len(webhookStats))
+	for _, s := range webhookStats {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Webhook < list[j].Webhook
+	})
+	return list
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+//────────────────────────────
+// Telegram Output (TELEGRAM_BOT_TOKEN, TELEGRAM_CHAT_ID)
+//────────────────────────────
+
+const telegramAPI = "https://api.telegram.org"
+
+// Longest text the Bot API accepts, in characters
+const telegramMaxText = 4096
+
+// Bot API sendMessage request, see https://core.telegram.org/bots/api#sendmessage
+type TelegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableNotification   bool   `json:"disable_notification,omitempty"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID add a Telegram destination, which
+// receives what DISCORD_WEBHOOK_URL receives
+func telegramConfigured(env Env) bool {
+	return env.TelegramBotToken != "" && env.TelegramChatID != ""
+}
+
+var (
+	markdownBold = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// Turn the Discord markdown used in messages (bold, links) into Telegram HTML
+func telegramHTML(text string) string {
+	text = html.EscapeString(text)
+	text = markdownBold.ReplaceAllString(text, "<b>$1</b>")
+	return markdownLink.ReplaceAllString(text, `<a href="$2">$1</a>`)
+}
+
+// Render a message as lines: the title, the description, then one block per
+// field (e.g. each intensity group with its prefectures)
+func telegramText(body MessageBody, content string) string {
+	var lines []string
+	if content != "" {
+		lines = append(lines, html.EscapeString(content))
+	}
+	lines = append(lines, "<b>"+html.EscapeString(body.Title)+"</b>")
+	if body.Description != "" {
+		lines = append(lines, telegramHTML(body.Description))
+	}
+	for _, field := range body.Fields {
+		lines = append(lines, "", "<b>"+html.EscapeString(field.Name)+"</b>", telegramHTML(field.Value))
+	}
+	if body.Footer != nil && body.Footer.Text != "" {
+		lines = append(lines, "", "<i>"+html.EscapeString(body.Footer.Text)+"</i>")
+	}
+	text := strings.Join(lines, "\n")
+	// Cut whole lines, so that no tag is left open
+	for len([]rune(text)) > telegramMaxText {
+		i := strings.LastIndex(text, "\n")
+		if i <= 0 {
+			return string([]rune(text)[:telegramMaxText-1]) + "…"
+		}
+		text = text[:i]
+	}
+	return text
+}
+
+func telegramPayload(chatID string, body MessageBody, mention bool) TelegramMessage {
+	// Telegram has no @everyone; a message without a mention is delivered silently instead
+	return TelegramMessage{
+		ChatID:                chatID,
+		Text:                  telegramText(body, messageContent(body, false, false)),
+		ParseMode:             "HTML",
+		DisableNotification:   !mention,
+		DisableWebPagePreview: true,
+	}
+}
+
+// Post a message to a Telegram chat. Outcomes are audited and counted like webhook sends.
+func sendTelegram(d Destination, body MessageBody, mention bool) (ok bool) {
+	urlStr := telegramAPI + "/bot" + d.BotToken + "/sendMessage"
+	// Chats of one bot fail independently (bot blocked, chat deleted), so the
+	// audit log, stats and breaker tell them apart by chat ID
+	target := urlStr + "?chat_id=" + url.QueryEscape(d.ChatID)
+	botID, _, _ := strings.Cut(d.BotToken, ":")
+	breaker := "telegram:" + botID + "/" + d.ChatID
+	status := 0
+	failure := ""
+	defer func() {
+		auditSend(body.EventID, target, status, 0, ok, "")
+		recordWebhookResult(target, status, ok, failure)
+		observeWebhook(ok)
+	}()
+
+	data, err := json.Marshal(telegramPayload(d.ChatID, body, mention))
+	if err != nil {
+		failure = err.Error()
+		logError("telegram_failed", "Error marshalling Telegram message: %v", err)
+		return false
+	}
+	if !breakerAllow(breaker) {
+		failure = "circuit open"
+		logThrottled("warn", "breaker:"+breaker, "Circuit open, not sending to Telegram chat %s", d.ChatID)
+		return false
+	}
+	var resp *http.Response
+	req, err := http.NewRequest("POST", urlStr, bytes.NewReader(data))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = doWebhookRequest(req)
+	}
+	if err != nil {
+		// The error carries the URL, token included
+		failure = strings.ReplaceAll(err.Error(), d.BotToken, "****")
+		breakerRecord(breaker, 0, err)
+		logError("telegram_failed", "Error sending Telegram message: %s", failure)
+		return false
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	breakerRecord(breaker, status, nil)
+	var result telegramResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	if status >= 400 || !result.OK {
+		failure = fmt.Sprintf("HTTP %d: %s", status, result.Description)
+		withFields(logFields{"event_id": body.EventID, "status": status}).error("telegram_failed", "Telegram error: %s", failure)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+//────────────────────────────
+// Webhook Messages (ticker mode, message links)
+//────────────────────────────
+
+// Message returned by Discord when posting with wait=true
+type WebhookMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+var (
+	tickerMu         sync.Mutex
+	tickerMessageIDs = make(map[string]string)
+	webhookGuildIDs  = make(map[string]string)
+)
+
+func tickerMessageID(webhookURL string) string {
+	tickerMu.Lock()
+	defer tickerMu.Unlock()
+	return tickerMessageIDs[webhookURL]
+}
+
+// Remember the message to edit for a webhook ("" forgets it, e.g. after it was deleted)
+func setTickerMessageID(webhookURL, id string) {
+	tickerMu.Lock()
+	defer tickerMu.Unlock()
+	if id == "" {
+		delete(tickerMessageIDs, webhookURL)
+		return
+	}
+	tickerMessageIDs[webhookURL] = id
+}
+
+// Add a query parameter to a webhook URL, keeping any existing ones (e.g. thread_id)
+func withQuery(webhookURL, key, value string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// URL of a message previously posted by the webhook
+func webhookMessageURL(webhookURL, id string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
+	}
+	u.Path += "/messages/" + id
+	return u.String()
+}
+
+// Guild of a webhook, looked up once and cached
+func webhookGuildID(webhookURL string) string {
+	tickerMu.Lock()
+	guildID, ok := webhookGuildIDs[webhookURL]
+	tickerMu.Unlock()
+	if ok {
+		return guildID
+	}
+	info, err := fetchWebhookInfo(webhookURL)
+	if err != nil {
+		logError("webhook", "Error looking up webhook guild: %v", err)
+		return ""
+	}
+	tickerMu.Lock()
+	webhookGuildIDs[webhookURL] = info.GuildID
+	tickerMu.Unlock()
+	return info.GuildID
+}
+
+// Jump link to a posted message (https://discord.com/channels/{guild}/{channel}/{message})
+func messageLink(webhookURL string, msg WebhookMessage) string {
+	guildID := webhookGuildID(webhookURL)
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, msg.ChannelID, msg.ID)
+}
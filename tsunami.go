@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//────────────────────────────
+// Tsunami Information (code 552)
+//────────────────────────────
+
+const (
+	tsunamiEmbedColor  = 0xE91E63
+	tsunamiLiftedColor = 0x2ECC71
+)
+
+// Grades from the most to the least severe
+var tsunamiGrades = []struct {
+	Grade    string
+	LabelKey string
+}{
+	{"MajorWarning", "grade_major_warning"},
+	{"Warning", "grade_warning"},
+	{"Watch", "grade_watch"},
+	{"Unknown", "grade_unknown"},
+}
+
+// Describe the quake a tsunami report belongs to (e.g. "the M7.1 quake reported at 14:32")
+func describeRelatedQuake(r quakeRecord) string {
+	desc := tr("related_quake")
+	if r.Magnitude > 0 {
+		desc = fmt.Sprintf(tr("related_quake_mag"), r.Magnitude)
+	}
+	if t, err := displayTime(r.OriginTime); err == nil {
+		desc += fmt.Sprintf(tr("related_quake_time"), t.Hour(), t.Minute())
+	}
+	return desc
+}
+
+// Top-line overview: the highest grade in effect and the number of areas per grade
+func tsunamiSummary(areas []TsunamiArea) string {
+	var counts []string
+	highest := ""
+	for _, g := range tsunamiGrades {
+		n := 0
+		for _, a := range areas {
+			if a.Grade == g.Grade {
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if highest == "" {
+			highest = tr(g.LabelKey)
+		}
+		if n == 1 {
+			counts = append(counts, fmt.Sprintf(tr("tsunami_area_one"), tr(g.LabelKey)))
+		} else {
+			counts = append(counts, fmt.Sprintf(tr("tsunami_area_count"), tr(g.LabelKey), n))
+		}
+	}
+	if highest == "" {
+		return ""
+	}
+	return fmt.Sprintf(tr("tsunami_highest"), highest) + "\n" + strings.Join(counts, ", ")
+}
+
+// Room left in a field for area lines, keeping space for the overflow note
+const tsunamiFieldBudget = 960
+
+// One area with what is known about it, e.g.
+// "Iwate (immediate) · arrival 15:20 · max 3m"
+func tsunamiAreaLine(a TsunamiArea) string {
+	parts := []string{translate(a.Name)}
+	if a.Immediate {
+		parts[0] += tr("tsunami_immediate")
+	}
+	if fh := a.FirstHeight; fh != nil {
+		if t, err := displayTime(fh.ArrivalTime); err == nil {
+			parts = append(parts, fmt.Sprintf(tr("tsunami_arrival"), t.Format("15:04")))
+		} else if fh.Condition != "" {
+			parts = append(parts, fh.Condition)
+		}
+	}
+	if mh := a.MaxHeight; mh != nil {
+		if mh.Description != "" {
+			parts = append(parts, fmt.Sprintf(tr("tsunami_max_height"), mh.Description))
+		} else if mh.Value > 0 {
+			parts = append(parts, fmt.Sprintf(tr("tsunami_max_height"), fmt.Sprintf("%gm", mh.Value)))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// JMA tsunami forecast areas and the prefectures whose coast they cover. Bays
+// and island groups are shared or name no prefecture, so they are listed
+// rather than guessed from the area name.
+var tsunamiAreaPrefectures = map[string][]string{
+	"北海道太平洋沿岸東部": {"北海道"},
+	"北海道太平洋沿岸中部": {"北海道"},
+	"北海道太平洋沿岸西部": {"北海道"},
+	"北海道日本海沿岸北部": {"北海道"},
+	"北海道日本海沿岸南部": {"北海道"},
+	"オホーツク海沿岸":   {"北海道"},
+	"陸奥湾":        {"青森県"},
+	"青森県日本海沿岸":   {"青森県"},
+	"青森県太平洋沿岸":   {"青森県"},
+	"岩手県":        {"岩手県"},
+	"宮城県":        {"宮城県"},
+	"秋田県":        {"秋田県"},
+	"山形県":        {"山形県"},
+	"福島県":        {"福島県"},
+	"茨城県":        {"茨城県"},
+	"千葉県九十九里・外房": {"千葉県"},
+	"千葉県内房":      {"千葉県"},
+	"東京湾内湾":      {"東京都", "千葉県", "神奈川県"},
+	"伊豆諸島":       {"東京都"},
+	"東京都伊豆諸島":    {"東京都"},
+	"小笠原諸島":      {"東京都"},
+	"東京都小笠原諸島":   {"東京都"},
+	"相模湾・三浦半島":   {"神奈川県"},
+	"新潟県上中下越":    {"新潟県"},
+	"佐渡":         {"新潟県"},
+	"富山県":        {"富山県"},
+	"石川県能登":      {"石川県"},
+	"石川県加賀":      {"石川県"},
+	"福井県":        {"福井県"},
+	"静岡県":        {"静岡県"},
+	"愛知県外海":      {"愛知県"},
+	"伊勢・三河湾":     {"愛知県", "三重県"},
+	"三重県南部":      {"三重県"},
+	"京都府":        {"京都府"},
+	"大阪府":        {"大阪府"},
+	"兵庫県北部":      {"兵庫県"},
+	"兵庫県瀬戸内海沿岸":  {"兵庫県"},
+	"淡路島南部":      {"兵庫県"},
+	"和歌山県":       {"和歌山県"},
+	"鳥取県":        {"鳥取県"},
+	"島根県出雲・石見":   {"島根県"},
+	"隠岐":         {"島根県"},
+	"岡山県":        {"岡山県"},
+	"広島県":        {"広島県"},
+	"徳島県":        {"徳島県"},
+	"香川県":        {"香川県"},
+	"愛媛県宇和海沿岸":   {"愛媛県"},
+	"愛媛県瀬戸内海沿岸":  {"愛媛県"},
+	"高知県":        {"高知県"},
+	"山口県日本海沿岸":   {"山口県"},
+	"山口県瀬戸内海沿岸":  {"山口県"},
+	"福岡県瀬戸内海沿岸":  {"福岡県"},
+	"福岡県日本海沿岸":   {"福岡県"},
+	"有明・八代海":     {"福岡県", "佐賀県", "長崎県", "熊本県", "鹿児島県"},
+	"佐賀県北部":      {"佐賀県"},
+	"長崎県西方":      {"長崎県"},
+	"壱岐・対馬":      {"長崎県"},
+	"熊本県天草灘沿岸":   {"熊本県"},
+	"大分県瀬戸内海沿岸":  {"大分県"},
+	"大分県豊後水道沿岸":  {"大分県"},
+	"宮崎県":        {"宮崎県"},
+	"鹿児島県東部":     {"鹿児島県"},
+	"鹿児島県西部":     {"鹿児島県"},
+	"種子島・屋久島地方":  {"鹿児島県"},
+	"奄美群島・トカラ列島": {"鹿児島県"},
+	"沖縄本島地方":     {"沖縄県"},
+	"大東島地方":      {"沖縄県"},
+	"宮古島・八重山地方":  {"沖縄県"},
+}
+
+// Prefectures (translated, sorted) whose coast has a forecast area. Areas
+// missing from the table are matched on a leading prefecture name, suffix
+// included, so that "東京都…" never reads as "京都".
+func tsunamiPrefectures(areas []TsunamiArea) []string {
+	var prefs []string
+	add := func(pref string) {
+		if en := translate(pref); !containsString(prefs, en) {
+			prefs = append(prefs, en)
+		}
+	}
+	for _, a := range areas {
+		if covered, ok := tsunamiAreaPrefectures[a.Name]; ok {
+			for _, pref := range covered {
+				add(pref)
+			}
+			continue
+		}
+		for jp := range translateMap {
+			if strings.HasPrefix(a.Name, jp) {
+				add(jp)
+			}
+		}
+	}
+	sort.Strings(prefs)
+	return prefs
+}
+
+// Notice posted for a cancelled tsunami report (TSUNAMI_CANCEL_NOTICE)
+func createTsunamiCancelMessage(isDev bool) MessageBody {
+	prefix := ""
+	if isDev {
+		prefix = tr("test_distribution")
+	}
+	color := tsunamiLiftedColor
+	if currentEnv().DisableColor {
+		color = 0
+	}
+	return MessageBody{
+		Title:       tr("tsunami_lifted_title"),
+		Description: prefix + tr("tsunami_lifted"),
+		Color:       color,
+	}
+}
+
+func createTsunamiMessage(t JMATsunami, isDev bool) MessageBody {
+	env := currentEnv()
+	prefix := ""
+	if isDev {
+		prefix = tr("test_distribution")
+	}
+	description := prefix + tr("tsunami_issued")
+	// Cross-reference the quake that most likely caused it
+	if related, ok := relatedQuake(time.Now(), env.TsunamiCorrelation); ok {
+		description = prefix + fmt.Sprintf(tr("tsunami_related"), describeRelatedQuake(related))
+		if related.Name != "" {
+			description += fmt.Sprintf(tr("tsunami_epicenter"), translate(related.Name))
+		}
+	}
+	if summary := tsunamiSummary(t.Areas); summary != "" {
+		description += "\n\n" + summary
+	}
+
+	// Grades are walked from the most severe, so with TSUNAMI_MAX_AREAS the
+	// higher-grade areas are listed first and the rest is only counted
+	remaining := env.TsunamiMaxAreas
+	var fields []MessageField
+	for _, g := range tsunamiGrades {
+		var names []string
+		hidden, length := 0, 0
+		for _, a := range t.Areas {
+			if a.Grade != g.Grade {
+				continue
+			}
+			line := tsunamiAreaLine(a)
+			// Discord rejects field values over 1024 characters
+			if (env.TsunamiMaxAreas > 0 && remaining <= 0) || length+len(line) > tsunamiFieldBudget {
+				hidden++
+				continue
+			}
+			names = append(names, line)
+			length += len(line) + 1
+			remaining--
+		}
+		if len(names) == 0 && hidden == 0 {
+			continue
+		}
+		value := strings.Join(names, "\n")
+		if hidden > 0 {
+			if value != "" {
+				value += "\n"
+			}
+			value += fmt.Sprintf(tr("more_areas"), hidden, tr(g.LabelKey))
+		}
+		fields = append(fields, MessageField{
+			Name:   tr(g.LabelKey),
+			Value:  value,
+			Inline: false,
+		})
+	}
+
+	color := tsunamiEmbedColor
+	if env.DisableColor {
+		color = 0
+	}
+	return MessageBody{
+		Title:       tr("tsunami_title"),
+		Description: description,
+		Fields:      fields,
+		Color:       color,
+	}
+}
+
+func handleTsunami(t JMATsunami, isDev bool) {
+	env := currentEnv()
+	if t.Cancelled {
+		if !env.TsunamiCancelNotice {
+			if env.EnableLogger {
+				logInfo("tsunami_skipped", "Tsunami information cancelled, skipping")
+			}
+			return
+		}
+		body := createTsunamiCancelMessage(isDev)
+		body.EventID = t.ID
+		body.Sandbox = isDev
+		if err := sendMessage(body); err != nil {
+			logError("tsunami", "Error sending message: %v", err)
+		}
+		return
+	}
+	prefs := tsunamiPrefectures(t.Areas)
+	if !affectsTargets(prefs) {
+		if env.EnableLogger {
+			logInfo("tsunami_skipped", "No target prefectures in the tsunami areas, skipping")
+		}
+		return
+	}
+	body := createTsunamiMessage(t, isDev)
+	body.EventID = t.ID
+	body.Prefectures = prefs
+	body.Sandbox = isDev
+	body.Tsunami = true
+	body.Dedup = "new event ID"
+	if err := sendMessage(body); err != nil {
+		logError("tsunami", "Error sending message: %v", err)
+	} else if env.EnableLogger {
+		withFields(logFields{"event_id": t.ID}).info("tsunami_posted", "Tsunami alert received and posted successfully.")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTsunamiPrefectures(t *testing.T) {
+	withEnv(t, Env{})
+	tests := []struct {
+		name  string
+		areas []string
+		want  []string
+	}{
+		{"prefecture area", []string{"岩手県"}, []string{"Iwate"}},
+		{"coastal section", []string{"千葉県九十九里・外房"}, []string{"Chiba"}},
+		{"bay shared by three", []string{"東京湾内湾"}, []string{"Chiba", "Kanagawa", "Tokyo"}},
+		{"islands are not Kyoto", []string{"東京都伊豆諸島"}, []string{"Tokyo"}},
+		{"islands without prefecture", []string{"伊豆諸島", "小笠原諸島"}, []string{"Tokyo"}},
+		{"bay naming no prefecture", []string{"相模湾・三浦半島"}, []string{"Kanagawa"}},
+		{"two prefectures", []string{"伊勢・三河湾"}, []string{"Aichi", "Mie"}},
+		{"inland sea", []string{"有明・八代海"}, []string{"Fukuoka", "Kagoshima", "Kumamoto", "Nagasaki", "Saga"}},
+		{"bay", []string{"陸奥湾"}, []string{"Aomori"}},
+		{"island", []string{"淡路島南部"}, []string{"Hyogo"}},
+		{"Kyoto itself", []string{"京都府"}, []string{"Kyoto"}},
+		{"deduplicated", []string{"北海道太平洋沿岸東部", "北海道日本海沿岸北部", "オホーツク海沿岸"}, []string{"Hokkaido"}},
+		{"unlisted area with prefecture prefix", []string{"静岡県伊豆半島"}, []string{"Shizuoka"}},
+		{"unknown area", []string{"どこか"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var areas []TsunamiArea
+			for _, name := range tt.areas {
+				areas = append(areas, TsunamiArea{Name: name})
+			}
+			if got := tsunamiPrefectures(areas); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tsunamiPrefectures(%v) = %v, want %v", tt.areas, got, tt.want)
+			}
+		})
+	}
+}
+
+// Every listed prefecture must be one quakes are matched against
+func TestTsunamiAreaTableNamesPrefectures(t *testing.T) {
+	for area, prefs := range tsunamiAreaPrefectures {
+		for _, pref := range prefs {
+			if _, ok := translateMap[pref]; !ok {
+				t.Errorf("%s lists unknown prefecture %s", area, pref)
+			}
+		}
+	}
+}
+
+// Tsunami warnings reach the targets and destinations of the coast they cover
+func TestTsunamiTargetsAndDestinations(t *testing.T) {
+	chiba := Destination{Type: "discord", URL: "https://discord.com/api/webhooks/1/a", Prefectures: []string{"Chiba"}}
+	tests := []struct {
+		name    string
+		area    string
+		targets []string
+		alert   bool
+	}{
+		{"bay shared with Chiba", "東京湾内湾", []string{"Chiba"}, true},
+		{"bay naming no prefecture", "相模湾・三浦半島", []string{"Kanagawa"}, true},
+		{"islands are not Kyoto", "東京都伊豆諸島", []string{"Kyoto"}, false},
+		{"other coast", "岩手県", []string{"Chiba"}, false},
+	}
+	for _, tt := range tests {
+		withEnv(t, Env{TargetPrefectures: tt.targets, Destinations: []Destination{chiba}})
+		prefs := tsunamiPrefectures([]TsunamiArea{{Name: tt.area}})
+		if got := affectsTargets(prefs); got != tt.alert {
+			t.Errorf("%s: affectsTargets(%v) = %v, want %v", tt.name, prefs, got, tt.alert)
+		}
+		toChiba := len(destinationsFor(prefs)) == 1
+		if want := containsString(prefs, "Chiba"); toChiba != want {
+			t.Errorf("%s: sent to the Chiba destination = %v, want %v", tt.name, toChiba, want)
+		}
+	}
+}
+
+// Quakes stay in the history for a correlation window longer than the swarm
+// window, without counting towards the swarm note
+func TestRelatedQuakeBeyondSwarmWindow(t *testing.T) {
+	withEnv(t, Env{TsunamiCorrelation: 3 * time.Hour, SwarmRadiusKm: 100})
+	recentMu.Lock()
+	prev := quakeHistory
+	quakeHistory = nil
+	recentMu.Unlock()
+	t.Cleanup(func() {
+		recentMu.Lock()
+		quakeHistory = prev
+		recentMu.Unlock()
+	})
+	quake := func(origin string, magnitude float64) JMAQuake {
+		var eq JMAQuake
+		eq.Earthquake.Time = origin
+		eq.Earthquake.Hypocenter = &Hypocenter{Name: "三陸沖", Latitude: 38.5, Longitude: 143.0, Magnitude: magnitude}
+		return eq
+	}
+	start := time.Now().Add(-2 * time.Hour)
+	recordQuake(quake("2024/01/01 10:00:00", 7.5), start)
+	if got := recordQuake(quake("2024/01/01 12:00:00", 5.0), start.Add(2*time.Hour)); got != 1 {
+		t.Errorf("swarm count = %d, want 1 (the first quake is outside the swarm window)", got)
+	}
+
+	related, ok := relatedQuake(start.Add(2*time.Hour), 3*time.Hour)
+	if !ok || related.Magnitude != 7.5 {
+		t.Errorf("relatedQuake = %+v, %v; want the M7.5 quake two hours earlier", related, ok)
+	}
+}
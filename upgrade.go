@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+//────────────────────────────
+// Intensity Upgrade Re-alerts (UPGRADE_REALERT)
+//────────────────────────────
+
+// What was already posted for one quake (keyed by its origin time)
+type postedQuake struct {
+	MaxScale  int
+	Mentioned bool
+	Time      time.Time
+}
+
+var (
+	postedMu     sync.Mutex
+	postedQuakes = make(map[string]postedQuake)
+)
+
+// The highest intensity posted so far for a quake
+func lastPosted(key string) (postedQuake, bool) {
+	postedMu.Lock()
+	defer postedMu.Unlock()
+	p, ok := postedQuakes[key]
+	return p, ok
+}
+
+// Decide the mention of a follow-up report and record it. An upgrade keeps
+// the mention computed from its new intensity, so a quiet first report that
+// grows past the thresholds still pings; a follow-up that does not raise the
+// intensity does not ping again once the quake was mentioned.
+func upgradeMention(body MessageBody, mention bool) bool {
+	postedMu.Lock()
+	defer postedMu.Unlock()
+	now := time.Now()
+	for k, p := range postedQuakes {
+		if now.Sub(p.Time) > seenRetention {
+			delete(postedQuakes, k)
+		}
+	}
+	prev, ok := postedQuakes[body.QuakeKey]
+	if ok && !body.Upgrade && prev.Mentioned {
+		mention = false
+	}
+	next := postedQuake{MaxScale: body.MaxScale, Mentioned: prev.Mentioned || mention, Time: now}
+	if prev.MaxScale > next.MaxScale {
+		next.MaxScale = prev.MaxScale
+	}
+	postedQuakes[body.QuakeKey] = next
+	return mention
+}
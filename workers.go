@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+//────────────────────────────
+// Message Workers (MAX_CONCURRENT_MESSAGES)
+//────────────────────────────
+
+// Messages waiting per worker before non-alert messages are dropped
+const workerQueueSize = 64
+
+type messageJob struct {
+	message []byte
+	isDev   bool
+}
+
+// One queue per worker, set up by startWorkers before the first message
+var workerQueues []chan messageJob
+
+// Messages queued or being handled, awaited on shutdown
+var inFlight sync.WaitGroup
+
+// Start n workers (at least one) that run onMessage
+func startWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	workerQueues = make([]chan messageJob, n)
+	for i := range workerQueues {
+		queue := make(chan messageJob, workerQueueSize)
+		workerQueues[i] = queue
+		go func() {
+			for job := range queue {
+				onMessage(job.message, job.isDev)
+				inFlight.Done()
+			}
+		}()
+	}
+}
+
+// Messages about the same event share a key, so that they land on the same
+// worker and are handled in arrival order: reports of one quake share its
+// origin time, and tsunami bulletins supersede each other.
+func orderingKey(message []byte) (key string, code int) {
+	var head struct {
+		BasicData
+		Earthquake struct {
+			Time string `json:"time"`
+		} `json:"earthquake"`
+	}
+	_ = json.Unmarshal(message, &head)
+	switch head.Code {
+	case 551:
+		if head.Earthquake.Time != "" {
+			return "quake:" + head.Earthquake.Time, head.Code
+		}
+	case 552:
+		return "tsunami", head.Code
+	}
+	return strconv.Itoa(head.Code) + ":" + head.ID, head.Code
+}
+
+// Earthquake reports and tsunami warnings are never dropped
+func isAlertCode(code int) bool {
+	return code == 551 || code == 552
+}
+
+// Queue a received message for its worker. When the queue is full, alerts
+// (551, 552) wait for room while other messages are dropped. Every message is
+// relayed to the fan-out subscribers first.
+func dispatchMessage(message []byte, isDev bool) {
+	observeMessage()
+	broadcastRaw(message)
+	key, code := orderingKey(message)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	queue := workerQueues[h.Sum32()%uint32(len(workerQueues))]
+
+	job := messageJob{message: message, isDev: isDev}
+	inFlight.Add(1)
+	select {
+	case queue <- job:
+	default:
+		if !isAlertCode(code) {
+			inFlight.Done()
+			logThrottled("warn", "dropped", "Message queue full, dropping message with code %d", code)
+			return
+		}
+		queue <- job
+	}
+}